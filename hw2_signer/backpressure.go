@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy says what a link does with an item when its buffer is full.
+type OverflowPolicy int
+
+const (
+	// PolicyBlock makes the producer wait until the consumer frees a slot.
+	PolicyBlock OverflowPolicy = iota
+	// PolicyDropNewest discards the incoming item.
+	PolicyDropNewest
+	// PolicyDropOldest discards the oldest buffered item to make room.
+	PolicyDropOldest
+	// PolicySpill writes items that do not fit into a temporary file.
+	PolicySpill
+)
+
+// LinkConfig describes the channel between two neighbouring jobs.
+// Dropped and Spilled are updated atomically while the pipeline runs.
+// Items lost to spill file errors are counted as Dropped, the first such
+// error is kept for SpillErr.
+type LinkConfig struct {
+	BufferSize int
+	Policy     OverflowPolicy
+	// SpillDir is where PolicySpill keeps its file, os.TempDir() if empty
+	SpillDir string
+
+	Dropped uint64
+	Spilled uint64
+
+	errMu    sync.Mutex
+	spillErr error
+}
+
+// DroppedCount returns the number of items lost on this link so far.
+func (lc *LinkConfig) DroppedCount() uint64 {
+	return atomic.LoadUint64(&lc.Dropped)
+}
+
+// SpilledCount returns the number of items that went through the spill file.
+func (lc *LinkConfig) SpilledCount() uint64 {
+	return atomic.LoadUint64(&lc.Spilled)
+}
+
+// ExecutePipelineWithLinks works like ExecutePipeline, links[i] configures
+// the channel between freeFlowJobs[i] and freeFlowJobs[i+1].
+// Missing or nil entries keep the default buffer of 1 with blocking.
+func ExecutePipelineWithLinks(links []*LinkConfig, freeFlowJobs ...job) {
+	var nextIn chan interface{} = make(chan interface{}, 1)
+	wg := &sync.WaitGroup{}
+	for i := range freeFlowJobs {
+		var lc *LinkConfig
+		// у последнего задания нет потребителя, его выход остаётся обычным
+		if i < len(links) && i < len(freeFlowJobs)-1 {
+			lc = links[i]
+		}
+		in := nextIn
+		out, consumerIn := newLink(wg, lc)
+		wg.Add(1)
+		go func(in, out chan interface{}, index int, lwg *sync.WaitGroup) {
+			defer lwg.Done()
+			defer close(out)
+			freeFlowJobs[index](in, out)
+		}(in, out, i, wg)
+		nextIn = consumerIn
+	}
+	wg.Wait()
+}
+
+// SpillErr returns the first spill file error of this link, if any.
+func (lc *LinkConfig) SpillErr() error {
+	lc.errMu.Lock()
+	defer lc.errMu.Unlock()
+	return lc.spillErr
+}
+
+// dropOnError counts n items lost to err.
+func (lc *LinkConfig) dropOnError(n int, err error) {
+	atomic.AddUint64(&lc.Dropped, uint64(n))
+	lc.errMu.Lock()
+	if lc.spillErr == nil {
+		lc.spillErr = err
+	}
+	lc.errMu.Unlock()
+}
+
+// newLink returns the channel a producer writes to and the channel its
+// consumer reads from. For the default and blocking links they are the same,
+// otherwise a pump goroutine counted in wg moves items between them.
+func newLink(wg *sync.WaitGroup, lc *LinkConfig) (chan interface{}, chan interface{}) {
+	if lc == nil {
+		ch := make(chan interface{}, 1)
+		return ch, ch
+	}
+	if lc.Policy == PolicyBlock {
+		size := lc.BufferSize
+		if size < 0 {
+			size = 0
+		}
+		ch := make(chan interface{}, size)
+		return ch, ch
+	}
+	raw := make(chan interface{})
+	out := make(chan interface{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		pumpLink(lc, raw, out)
+	}()
+	return raw, out
+}
+
+// pumpLink moves items from raw to out applying the overflow policy.
+// It closes out once raw is closed and everything buffered is delivered.
+func pumpLink(lc *LinkConfig, raw, out chan interface{}) {
+	size := lc.BufferSize
+	if size < 1 {
+		size = 1
+	}
+	queue := make([]interface{}, 0, size)
+	var sp *spill
+
+	push := func(val interface{}) {
+		switch {
+		case len(queue) < size && (sp == nil || sp.pending == 0):
+			queue = append(queue, val)
+		case lc.Policy == PolicyDropNewest:
+			atomic.AddUint64(&lc.Dropped, 1)
+		case lc.Policy == PolicyDropOldest:
+			atomic.AddUint64(&lc.Dropped, 1)
+			queue = append(queue[1:], val)
+		case lc.Policy == PolicySpill:
+			if sp == nil {
+				var err error
+				if sp, err = newSpill(lc.SpillDir); err != nil {
+					lc.dropOnError(1, err)
+					return
+				}
+			}
+			// на полном диске теряем элемент, а не роняем процесс
+			if err := sp.write(val); err != nil {
+				lc.dropOnError(1, err)
+				return
+			}
+			atomic.AddUint64(&lc.Spilled, 1)
+		}
+	}
+	pop := func() {
+		queue = queue[1:]
+		if sp != nil && sp.pending > 0 {
+			val, err := sp.read()
+			if err != nil {
+				// после ошибки чтения остальное в файле уже не разобрать
+				lc.dropOnError(sp.pending, err)
+				sp.pending = 0
+				return
+			}
+			queue = append(queue, val)
+		}
+	}
+
+	for raw != nil || len(queue) > 0 {
+		var sendCh chan interface{}
+		var next interface{}
+		if len(queue) > 0 {
+			sendCh = out
+			next = queue[0]
+		}
+		select {
+		case val, ok := <-raw:
+			if !ok {
+				raw = nil
+				continue
+			}
+			push(val)
+		case sendCh <- next:
+			pop()
+		}
+	}
+	// файл убираем до close(out), чтобы после конца конвейера его не осталось
+	if sp != nil {
+		sp.close()
+	}
+	close(out)
+}
+
+// spill is a FIFO of gob-encoded items backed by a temporary file.
+// Values of non-builtin types have to be registered with gob.Register.
+type spill struct {
+	file    *os.File
+	reader  *os.File
+	enc     *gob.Encoder
+	dec     *gob.Decoder
+	pending int
+}
+
+func newSpill(dir string) (*spill, error) {
+	file, err := ioutil.TempFile(dir, "signer-spill-")
+	if err != nil {
+		return nil, err
+	}
+	reader, err := os.Open(file.Name())
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &spill{
+		file:   file,
+		reader: reader,
+		enc:    gob.NewEncoder(file),
+		dec:    gob.NewDecoder(reader),
+	}, nil
+}
+
+func (s *spill) write(val interface{}) error {
+	if err := s.enc.Encode(&val); err != nil {
+		return err
+	}
+	s.pending++
+	return nil
+}
+
+func (s *spill) read() (interface{}, error) {
+	var val interface{}
+	if err := s.dec.Decode(&val); err != nil {
+		return nil, err
+	}
+	s.pending--
+	return val, nil
+}
+
+func (s *spill) close() {
+	s.reader.Close()
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
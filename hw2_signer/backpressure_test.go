@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func produceInts(n int) job {
+	return job(func(in, out chan interface{}) {
+		for i := 0; i < n; i++ {
+			out <- i
+		}
+	})
+}
+
+func slowCollect(res *[]int, delay time.Duration) job {
+	return job(func(in, out chan interface{}) {
+		for val := range in {
+			time.Sleep(delay)
+			*res = append(*res, val.(int))
+		}
+	})
+}
+
+func TestLinkDropNewest(t *testing.T) {
+	var res []int
+	link := &LinkConfig{BufferSize: 2, Policy: PolicyDropNewest}
+	ExecutePipelineWithLinks([]*LinkConfig{link},
+		produceInts(100),
+		slowCollect(&res, time.Millisecond),
+	)
+	if len(res)+int(link.DroppedCount()) != 100 {
+		t.Errorf("lost items: got %d, dropped %d", len(res), link.DroppedCount())
+	}
+	if link.DroppedCount() == 0 {
+		t.Errorf("expected some items to be dropped")
+	}
+	for i := 1; i < len(res); i++ {
+		if res[i] <= res[i-1] {
+			t.Fatalf("order broken: %v", res)
+		}
+	}
+	if res[0] != 0 {
+		t.Errorf("first item should survive drop newest, got %d", res[0])
+	}
+}
+
+func TestLinkDropOldest(t *testing.T) {
+	var res []int
+	link := &LinkConfig{BufferSize: 2, Policy: PolicyDropOldest}
+	ExecutePipelineWithLinks([]*LinkConfig{link},
+		produceInts(100),
+		slowCollect(&res, time.Millisecond),
+	)
+	if len(res)+int(link.DroppedCount()) != 100 {
+		t.Errorf("lost items: got %d, dropped %d", len(res), link.DroppedCount())
+	}
+	if res[len(res)-1] != 99 {
+		t.Errorf("last item should survive drop oldest, got %d", res[len(res)-1])
+	}
+}
+
+func TestLinkSpill(t *testing.T) {
+	var res []int
+	link := &LinkConfig{BufferSize: 3, Policy: PolicySpill, SpillDir: t.TempDir()}
+	start := time.Now()
+	ExecutePipelineWithLinks([]*LinkConfig{link},
+		produceInts(50),
+		slowCollect(&res, time.Millisecond),
+	)
+	if len(res) != 50 {
+		t.Fatalf("expected all 50 items, got %d", len(res))
+	}
+	for i, val := range res {
+		if val != i {
+			t.Fatalf("order broken at %d: %v", i, res)
+		}
+	}
+	if link.SpilledCount() == 0 || link.DroppedCount() != 0 {
+		t.Errorf("unexpected counters: spilled %d, dropped %d", link.SpilledCount(), link.DroppedCount())
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("spill took too long")
+	}
+}
+
+func TestLinkBlockBuffer(t *testing.T) {
+	sent := make(chan struct{})
+	var res []int
+	ExecutePipelineWithLinks([]*LinkConfig{{BufferSize: 10}},
+		job(func(in, out chan interface{}) {
+			for i := 0; i < 10; i++ {
+				out <- i
+			}
+			close(sent)
+		}),
+		job(func(in, out chan interface{}) {
+			<-sent
+			for val := range in {
+				res = append(res, val.(int))
+			}
+		}),
+	)
+	if len(res) != 10 {
+		t.Errorf("expected 10 items, got %d", len(res))
+	}
+}
+
+func TestLinkSpillError(t *testing.T) {
+	var res []int
+	link := &LinkConfig{BufferSize: 3, Policy: PolicySpill, SpillDir: t.TempDir() + "/missing"}
+	ExecutePipelineWithLinks([]*LinkConfig{link},
+		produceInts(50),
+		slowCollect(&res, time.Millisecond),
+	)
+	if link.SpillErr() == nil {
+		t.Fatalf("expected a spill error")
+	}
+	if len(res)+int(link.DroppedCount()) != 50 || link.DroppedCount() == 0 {
+		t.Errorf("lost items: got %d, dropped %d", len(res), link.DroppedCount())
+	}
+}
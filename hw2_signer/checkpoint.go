@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Checkpoint remembers per-item outputs of pipeline stages in an
+// append-only file of JSON lines, so a restarted pipeline can skip
+// items that were already processed.
+//
+// Outputs are stored as JSON, so checkpointed stages should emit strings
+// (SingleHash and MultiHash do).
+type Checkpoint struct {
+	mu   sync.Mutex
+	file *os.File
+	done map[string][]interface{}
+	// err is the first failed write, see Err
+	err error
+}
+
+type checkpointRecord struct {
+	Stage string        `json:"stage"`
+	Key   string        `json:"key"`
+	Out   []interface{} `json:"out"`
+}
+
+// OpenCheckpoint loads an existing checkpoint file or creates a new one.
+// A torn last line left by a crash is ignored.
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{
+		file: file,
+		done: make(map[string][]interface{}),
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		rec := checkpointRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		cp.done[checkpointKey(rec.Stage, rec.Key)] = rec.Out
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := terminateLastLine(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return cp, nil
+}
+
+// ExecuteCheckpointed runs ExecutePipeline and returns the first error
+// of writing cp. Outputs of items that failed to persist still go down
+// the pipeline, they are just computed again on the next run.
+func ExecuteCheckpointed(cp *Checkpoint, freeFlowJobs ...job) error {
+	ExecutePipeline(freeFlowJobs...)
+	return cp.Err()
+}
+
+// Err returns the first error of writing the checkpoint, if any.
+func (cp *Checkpoint) Err() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.err
+}
+
+// Close closes the underlying file.
+func (cp *Checkpoint) Close() error {
+	return cp.file.Close()
+}
+
+// Len returns the number of completed stage items known to the checkpoint.
+func (cp *Checkpoint) Len() int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return len(cp.done)
+}
+
+// Stage wraps a job so that every input item is processed on its own,
+// its outputs are persisted under the stage name and replayed on the
+// next run instead of recomputing them. Items are identified by their
+// type and value, equal items share one record.
+func (cp *Checkpoint) Stage(name string, stage job) job {
+	return job(func(in, out chan interface{}) {
+		wg := &sync.WaitGroup{}
+		for val := range in {
+			key := fmt.Sprintf("%T:%v", val, val)
+			if res, ok := cp.lookup(name, key); ok {
+				for _, r := range res {
+					out <- r
+				}
+				continue
+			}
+			wg.Add(1)
+			go func(val interface{}, key string) {
+				defer wg.Done()
+				res := runSingle(stage, val)
+				cp.save(name, key, res)
+				for _, r := range res {
+					out <- r
+				}
+			}(val, key)
+		}
+		wg.Wait()
+	})
+}
+
+func (cp *Checkpoint) lookup(stage, key string) ([]interface{}, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	res, ok := cp.done[checkpointKey(stage, key)]
+	return res, ok
+}
+
+// save persists a record and syncs the file before the item's outputs go
+// further, so an item reported done survives a crash. A failure is kept
+// for Err.
+func (cp *Checkpoint) save(stage, key string, res []interface{}) {
+	line, err := json.Marshal(&checkpointRecord{Stage: stage, Key: key, Out: res})
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if err == nil {
+		if _, err = cp.file.Write(append(line, '\n')); err == nil {
+			err = cp.file.Sync()
+		}
+	}
+	if err != nil {
+		if cp.err == nil {
+			cp.err = fmt.Errorf("checkpoint %s: %s", stage, err)
+		}
+		return
+	}
+	cp.done[checkpointKey(stage, key)] = res
+}
+
+// terminateLastLine makes sure new records do not get glued to a torn one.
+func terminateLastLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = file.Write([]byte{'\n'})
+	}
+	return err
+}
+
+func checkpointKey(stage, key string) string {
+	return stage + "\x00" + key
+}
+
+// runSingle feeds one item to a job and collects everything it emits.
+func runSingle(stage job, val interface{}) []interface{} {
+	in := make(chan interface{}, 1)
+	out := make(chan interface{}, 1)
+	in <- val
+	close(in)
+	go func() {
+		defer close(out)
+		stage(in, out)
+	}()
+	res := []interface{}{}
+	for r := range out {
+		res = append(res, r)
+	}
+	return res
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signer.checkpoint")
+	var calls uint32
+	stage := job(func(in, out chan interface{}) {
+		for val := range in {
+			atomic.AddUint32(&calls, 1)
+			out <- "h" + strconv.Itoa(val.(int))
+		}
+	})
+
+	run := func(n int) string {
+		cp, err := OpenCheckpoint(path)
+		if err != nil {
+			t.Fatalf("cant open checkpoint: %s", err)
+		}
+		defer cp.Close()
+		result := ""
+		err = ExecuteCheckpointed(cp,
+			produceInts(n),
+			cp.Stage("hash", stage),
+			job(CombineResults),
+			job(func(in, out chan interface{}) {
+				result = (<-in).(string)
+			}),
+		)
+		if err != nil {
+			t.Fatalf("checkpoint error: %s", err)
+		}
+		return result
+	}
+
+	run(4)
+	if calls != 4 {
+		t.Fatalf("expected 4 calls, got %d", calls)
+	}
+
+	// симулируем падение посреди записи
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"stage":"hash","key":"int:4","ou`)
+	f.Close()
+
+	result := run(6)
+	if calls != 6 {
+		t.Errorf("expected only 2 new calls, got %d total", calls)
+	}
+	expected := "h0_h1_h2_h3_h4_h5"
+	if result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	if result := run(6); result != expected || calls != 6 {
+		t.Errorf("full replay failed: %s, %d calls", result, calls)
+	}
+}
+
+func TestCheckpointWriteError(t *testing.T) {
+	cp, err := OpenCheckpoint(filepath.Join(t.TempDir(), "signer.checkpoint"))
+	if err != nil {
+		t.Fatalf("cant open checkpoint: %s", err)
+	}
+	// запись в закрытый файл падает
+	cp.Close()
+	stage := job(func(in, out chan interface{}) {
+		for val := range in {
+			out <- "h" + strconv.Itoa(val.(int))
+		}
+	})
+	result := ""
+	err = ExecuteCheckpointed(cp,
+		produceInts(3),
+		cp.Stage("hash", stage),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			result = (<-in).(string)
+		}),
+	)
+	if err == nil {
+		t.Errorf("expected a checkpoint error")
+	}
+	if result != "h0_h1_h2" {
+		t.Errorf("results not match: %s", result)
+	}
+	if cp.Len() != 0 {
+		t.Errorf("failed records counted as done: %d", cp.Len())
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// signedItem is a single input line together with its MultiHash result.
+type signedItem struct {
+	Index int    `json:"index"`
+	Data  string `json:"data"`
+	Hash  string `json:"hash"`
+}
+
+type signConfig struct {
+	strings     bool
+	concurrency int
+	format      string
+	files       []string
+	network     string
+	serve       string
+	remote      string
+}
+
+func main() {
+	if err := runCLI(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// runCLI signs every line from the given files (stdin if none or "-")
+// with SingleHash -> MultiHash, prints per-item results and the
+// CombineResults output, and reports timing to stderr.
+func runCLI(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("signer", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	cfg := signConfig{}
+	salt := fs.String("salt", DataSignerSalt, "salt appended to signed data (DataSignerSalt)")
+	fs.BoolVar(&cfg.strings, "strings", false, "sign lines as arbitrary strings instead of integers")
+	fs.IntVar(&cfg.concurrency, "concurrency", 64, "max items signed at once, 0 for unlimited")
+	fs.StringVar(&cfg.format, "format", "plain", "output format: plain or json")
+	fs.StringVar(&cfg.network, "network", "tcp", "network for -serve and -remote: tcp or unix")
+	fs.StringVar(&cfg.serve, "serve", "", "run as a MultiHash worker listening on this address")
+	fs.StringVar(&cfg.remote, "remote", "", "comma separated MultiHash worker addresses")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	DataSignerSalt = *salt
+	if cfg.serve != "" {
+		l, err := net.Listen(cfg.network, cfg.serve)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stderr, "serving MultiHash on", l.Addr())
+		return ServeStage(l, job(MultiHash))
+	}
+	if cfg.format != "plain" && cfg.format != "json" {
+		return fmt.Errorf("unknown format %q", cfg.format)
+	}
+	cfg.files = fs.Args()
+	if len(cfg.files) == 0 {
+		cfg.files = []string{"-"}
+	}
+	multiHash := job(MultiHash)
+	var remote *RemoteStage
+	if cfg.remote != "" {
+		remote = NewRemoteStage(cfg.network, strings.Split(cfg.remote, ",")...)
+		defer remote.Close()
+		multiHash = remote.Job()
+	}
+
+	var (
+		readErr  error
+		writeErr error
+		count    int
+	)
+	start := time.Now()
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			readErr = readInputs(cfg, stdin, out)
+		}),
+		signItems(cfg.concurrency, signChain(multiHash)),
+		job(func(in, out chan interface{}) {
+			enc := json.NewEncoder(stdout)
+			for val := range in {
+				item := val.(*signedItem)
+				count++
+				if writeErr == nil {
+					if cfg.format == "json" {
+						writeErr = enc.Encode(item)
+					} else {
+						_, writeErr = fmt.Fprintf(stdout, "%d\t%s\t%s\n", item.Index, item.Data, item.Hash)
+					}
+				}
+				out <- item.Hash
+			}
+		}),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				if writeErr != nil {
+					continue
+				}
+				if cfg.format == "json" {
+					writeErr = json.NewEncoder(stdout).Encode(map[string]string{"combined": val.(string)})
+				} else {
+					_, writeErr = fmt.Fprintln(stdout, "combined", val)
+				}
+			}
+		}),
+	)
+	fmt.Fprintf(stderr, "signed %d items in %s\n", count, time.Since(start))
+	if readErr != nil {
+		return readErr
+	}
+	if remote != nil && remote.Err() != nil {
+		return remote.Err()
+	}
+	return writeErr
+}
+
+// readInputs sends lines of all input files to out, numbering them
+// across files. It stops at the first bad file or integer.
+func readInputs(cfg signConfig, stdin io.Reader, out chan interface{}) error {
+	index := 0
+	for _, name := range cfg.files {
+		var err error
+		if name == "-" {
+			index, err = readInput(cfg, name, stdin, index, out)
+		} else {
+			index, err = readFile(cfg, name, index, out)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFile reads one input file and closes it before the next one is opened.
+func readFile(cfg signConfig, name string, index int, out chan interface{}) (int, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return index, err
+	}
+	defer file.Close()
+	return readInput(cfg, name, file, index, out)
+}
+
+// readInput sends lines of r to out numbered from index and returns the
+// next free index.
+func readInput(cfg signConfig, name string, r io.Reader, index int, out chan interface{}) (int, error) {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if !cfg.strings {
+			text = strings.TrimSpace(text)
+			if text == "" {
+				continue
+			}
+			num, err := strconv.Atoi(text)
+			if err != nil {
+				return index, fmt.Errorf("%s:%d: not an integer: %q", name, line, text)
+			}
+			text = strconv.Itoa(num)
+		}
+		out <- &signedItem{Index: index, Data: text}
+		index++
+	}
+	return index, scanner.Err()
+}
+
+// signItems runs SingleHash -> MultiHash for every item separately so that
+// results can be matched with their inputs, at most limit items at once.
+func signItems(limit int, chain job) job {
+	return job(func(in, out chan interface{}) {
+		var sem chan struct{}
+		if limit > 0 {
+			sem = make(chan struct{}, limit)
+		}
+		wg := &sync.WaitGroup{}
+		for val := range in {
+			item := val.(*signedItem)
+			if sem != nil {
+				sem <- struct{}{}
+			}
+			wg.Add(1)
+			go func(item *signedItem) {
+				defer wg.Done()
+				res := runSingle(chain, item.Data)
+				if sem != nil {
+					<-sem
+				}
+				// пусто, если живых воркеров не осталось, ошибку вернет RemoteStage.Err
+				if len(res) == 0 {
+					return
+				}
+				item.Hash = res[0].(string)
+				out <- item
+			}(item)
+		}
+		wg.Wait()
+	})
+}
+
+// signChain packs SingleHash followed by multiHash into one job.
+func signChain(multiHash job) job {
+	return job(func(in, out chan interface{}) {
+		ExecutePipeline(
+			job(func(_, mid chan interface{}) {
+				for val := range in {
+					mid <- val
+				}
+			}),
+			job(SingleHash),
+			multiHash,
+			job(func(mid, _ chan interface{}) {
+				for val := range mid {
+					out <- val
+				}
+			}),
+		)
+	})
+}
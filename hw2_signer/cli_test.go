@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCLIFormats(t *testing.T) {
+	prevMd5, prevCrc32, prevSalt := DataSignerMd5, DataSignerCrc32, DataSignerSalt
+	defer func() {
+		DataSignerMd5, DataSignerCrc32, DataSignerSalt = prevMd5, prevCrc32, prevSalt
+	}()
+	DataSignerMd5 = func(data string) string { return "m" + data + DataSignerSalt }
+	DataSignerCrc32 = func(data string) string { return "c" + data + DataSignerSalt }
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	err := runCLI([]string{"-format", "json", "-salt", "s"}, strings.NewReader("1\n\n 2 \n"), stdout, stderr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dec := json.NewDecoder(stdout)
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		item := signedItem{}
+		if err := dec.Decode(&item); err != nil {
+			t.Fatalf("cant decode item: %s", err)
+		}
+		seen[item.Data] = true
+		if !strings.HasPrefix(item.Hash, "c0c"+item.Data+"s~") {
+			t.Errorf("unexpected hash for %s: %s", item.Data, item.Hash)
+		}
+	}
+	if !seen["1"] || !seen["2"] {
+		t.Errorf("missing items: %v", seen)
+	}
+	combined := map[string]string{}
+	if err := dec.Decode(&combined); err != nil || combined["combined"] == "" {
+		t.Errorf("no combined result: %v %v", combined, err)
+	}
+	if !strings.Contains(stderr.String(), "signed 2 items") {
+		t.Errorf("no timing report: %q", stderr.String())
+	}
+
+	stdout.Reset()
+	err = runCLI([]string{"-strings", "-concurrency", "1"}, strings.NewReader("a b\n"), stdout, stderr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.HasPrefix(stdout.String(), "0\ta b\t") || !strings.Contains(stdout.String(), "\ncombined ") {
+		t.Errorf("unexpected plain output: %q", stdout.String())
+	}
+
+	if err := runCLI(nil, strings.NewReader("x\n"), stdout, stderr); err == nil {
+		t.Errorf("expected error for non integer input")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeClock is a virtual clock: Sleep blocks until the clock is advanced
+// past the sleeper's deadline, either manually with Advance or by
+// AutoAdvance once all goroutines are asleep.
+type FakeClock struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	now      time.Time
+	sleepers []*fakeSleeper
+	// version changes every time someone falls asleep
+	version uint64
+}
+
+type fakeSleeper struct {
+	until time.Time
+	wake  chan struct{}
+}
+
+// NewFakeClock returns a FakeClock showing start.
+func NewFakeClock(start time.Time) *FakeClock {
+	c := &FakeClock{now: start}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.mu.Lock()
+	s := &fakeSleeper{until: c.now.Add(d), wake: make(chan struct{})}
+	c.sleepers = append(c.sleepers, s)
+	c.version++
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	<-s.wake
+}
+
+// Sleepers returns the number of goroutines blocked in Sleep.
+func (c *FakeClock) Sleepers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.sleepers)
+}
+
+// BlockUntil waits until at least n goroutines are blocked in Sleep.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.sleepers) < n {
+		c.cond.Wait()
+	}
+}
+
+// Advance moves the clock forward and wakes sleepers whose deadline passed.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advanceTo(c.now.Add(d))
+}
+
+func (c *FakeClock) advanceTo(t time.Time) {
+	if t.After(c.now) {
+		c.now = t
+	}
+	left := c.sleepers[:0]
+	for _, s := range c.sleepers {
+		if s.until.After(c.now) {
+			left = append(left, s)
+			continue
+		}
+		close(s.wake)
+	}
+	c.sleepers = left
+}
+
+// AutoAdvance starts moving the clock to the nearest deadline every time
+// nobody has fallen asleep for idle of real time. Call stop when done.
+func (c *FakeClock) AutoAdvance(idle time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(idle)
+		defer ticker.Stop()
+		c.mu.Lock()
+		seen := c.version
+		c.mu.Unlock()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			c.mu.Lock()
+			if c.version == seen && len(c.sleepers) > 0 {
+				sort.Slice(c.sleepers, func(i, j int) bool {
+					return c.sleepers[i].until.Before(c.sleepers[j].until)
+				})
+				c.advanceTo(c.sleepers[0].until)
+			}
+			seen = c.version
+			c.mu.Unlock()
+		}
+	}()
+	return func() { close(done) }
+}
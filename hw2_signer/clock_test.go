@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFakeClockAdvance(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	done := make(chan struct{})
+	go func() {
+		clock.Sleep(time.Second)
+		close(done)
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(500 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatalf("woke up too early")
+	default:
+	}
+	clock.Advance(500 * time.Millisecond)
+	<-done
+	if clock.Sleepers() != 0 {
+		t.Errorf("sleeper left behind")
+	}
+	if got := clock.Now().Sub(time.Unix(0, 0)); got != time.Second {
+		t.Errorf("wrong time: %s", got)
+	}
+}
+
+// overlapClock counts how many one-second sleeps (crc32 calls) overlap
+type overlapClock struct {
+	*FakeClock
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (c *overlapClock) Sleep(d time.Duration) {
+	if d != time.Second {
+		c.FakeClock.Sleep(d)
+		return
+	}
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.mu.Unlock()
+	c.FakeClock.Sleep(d)
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+}
+
+// TestSignerSchedule проверяет расписание вызовов в виртуальном времени:
+// все crc32 из SingleHash стартуют одновременно, а весь расчет укладывается в 3 сек.
+// Работают настоящие функции из common.go, подменяются только часы
+func TestSignerSchedule(t *testing.T) {
+	clock := &overlapClock{FakeClock: NewFakeClock(time.Unix(0, 0))}
+	prevClock := SignerClock
+	defer func() { SignerClock = prevClock }()
+	SignerClock = clock
+
+	stop := clock.AutoAdvance(5 * time.Millisecond)
+	defer stop()
+
+	inputData := []int{0, 1, 1, 2, 3, 5, 8}
+	expected := "1173136728138862632818075107442090076184424490584241521304_1696913515191343735512658979631549563179965036907783101867_27225454331033649287118297354036464389062965355426795162684_29568666068035183841425683795340791879727309630931025356555_3994492081516972096677631278379039212655368881548151736_4958044192186797981418233587017209679042592862002427381542_4958044192186797981418233587017209679042592862002427381542"
+	result := ""
+	realStart := time.Now()
+	start := clock.Now()
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for _, fibNum := range inputData {
+				out <- fibNum
+			}
+		}),
+		job(SingleHash),
+		job(MultiHash),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			result = (<-in).(string)
+		}),
+	)
+	elapsed := clock.Now().Sub(start)
+
+	if result != expected {
+		t.Fatalf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+	// перегрев md5 спит по секунде, так что он тоже вылезет здесь
+	if elapsed > 3*time.Second {
+		t.Errorf("execution too long in virtual time: %s", elapsed)
+	}
+	if clock.maxInFlight < len(inputData) {
+		t.Errorf("crc32 calls do not overlap: max %d in flight", clock.maxInFlight)
+	}
+	if real := time.Since(realStart); real > time.Second {
+		t.Errorf("fake clock run took %s of real time", real)
+	}
+}
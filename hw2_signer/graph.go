@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Node is a job placed in a pipeline graph.
+type Node struct {
+	Name string
+	Job  job
+	// Inputs are names of nodes whose output is merged into this node's input.
+	// A node without inputs gets an already closed input channel.
+	Inputs []string
+	// Route picks the consumers that receive an output item.
+	// nil broadcasts every item to all consumers.
+	Route func(val interface{}) []string
+	// Link configures the channel to each consumer, see LinkConfig. Every
+	// consumer gets its own channel with this buffer size and policy, the
+	// counters add up over all of them.
+	Link *LinkConfig
+}
+
+// ValidateGraph checks that node names are unique, every input refers
+// to an existing node and there are no cycles. It returns nodes in
+// topological order.
+func ValidateGraph(nodes ...Node) ([]string, error) {
+	byName := make(map[string]int, len(nodes))
+	for i, n := range nodes {
+		if n.Name == "" {
+			return nil, fmt.Errorf("node %d has no name", i)
+		}
+		if n.Job == nil {
+			return nil, fmt.Errorf("node %s has no job", n.Name)
+		}
+		if _, ok := byName[n.Name]; ok {
+			return nil, fmt.Errorf("duplicate node %s", n.Name)
+		}
+		byName[n.Name] = i
+	}
+
+	indegree := make(map[string]int, len(nodes))
+	consumers := make(map[string][]string, len(nodes))
+	for _, n := range nodes {
+		seen := make(map[string]bool, len(n.Inputs))
+		for _, input := range n.Inputs {
+			if _, ok := byName[input]; !ok {
+				return nil, fmt.Errorf("node %s: dangling input %s", n.Name, input)
+			}
+			if seen[input] {
+				return nil, fmt.Errorf("node %s: input %s listed twice", n.Name, input)
+			}
+			seen[input] = true
+			indegree[n.Name]++
+			consumers[input] = append(consumers[input], n.Name)
+		}
+	}
+
+	order := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		if indegree[n.Name] == 0 {
+			order = append(order, n.Name)
+		}
+	}
+	for i := 0; i < len(order); i++ {
+		for _, c := range consumers[order[i]] {
+			indegree[c]--
+			if indegree[c] == 0 {
+				order = append(order, c)
+			}
+		}
+	}
+	if len(order) != len(nodes) {
+		return nil, fmt.Errorf("graph has a cycle")
+	}
+	return order, nil
+}
+
+// ExecuteGraph runs every node concurrently, fanning each output out to
+// its consumers and merging inputs. Channels are closed as soon as all
+// their producers finish, so range loops terminate like in ExecutePipeline.
+// Every consumer of a node gets its own link, so a slow consumer holds
+// back the producer and the other consumers only once its buffer is full
+// and only if the policy blocks. It returns when all goroutines it started
+// are done; items routed to names that are not consumers of the node are
+// dropped and reported in the error.
+func ExecuteGraph(nodes ...Node) error {
+	if _, err := ValidateGraph(nodes...); err != nil {
+		return err
+	}
+
+	wg := &sync.WaitGroup{}
+	links := make(map[string]*LinkConfig, len(nodes))
+	for _, n := range nodes {
+		links[n.Name] = n.Link
+	}
+	// an item goes from a node to one of its consumers through
+	// sends[from][to] and comes out of edges[from][to]
+	sends := make(map[string]map[string]chan interface{}, len(nodes))
+	edges := make(map[string]map[string]chan interface{}, len(nodes))
+	for _, n := range nodes {
+		for _, input := range n.Inputs {
+			if edges[input] == nil {
+				sends[input] = make(map[string]chan interface{})
+				edges[input] = make(map[string]chan interface{})
+			}
+			sends[input][n.Name], edges[input][n.Name] = newLink(wg, links[input])
+		}
+	}
+
+	unrouted := make([]map[string]int, len(nodes))
+	for i, n := range nodes {
+		in := make(chan interface{}, 1)
+		mergeInputs(wg, in, n.Inputs, n.Name, edges)
+
+		out := make(chan interface{}, 1)
+		unrouted[i] = make(map[string]int)
+		wg.Add(1)
+		go func(out chan interface{}, targets map[string]chan interface{}, route func(interface{}) []string, unknown map[string]int) {
+			defer wg.Done()
+			fanOut(out, targets, route, unknown)
+		}(out, sends[n.Name], n.Route, unrouted[i])
+
+		wg.Add(1)
+		go func(nodeJob job, in, out chan interface{}) {
+			defer wg.Done()
+			defer close(out)
+			nodeJob(in, out)
+		}(n.Job, in, out)
+	}
+	wg.Wait()
+
+	for i, unknown := range unrouted {
+		names := make([]string, 0, len(unknown))
+		for name := range unknown {
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			continue
+		}
+		sort.Strings(names)
+		return fmt.Errorf("node %s routed %d items to unknown consumer %s", nodes[i].Name, unknown[names[0]], names[0])
+	}
+	return nil
+}
+
+func mergeInputs(wg *sync.WaitGroup, in chan interface{}, inputs []string, name string, edges map[string]map[string]chan interface{}) {
+	mwg := &sync.WaitGroup{}
+	for _, input := range inputs {
+		mwg.Add(1)
+		go func(edge chan interface{}) {
+			defer mwg.Done()
+			for val := range edge {
+				in <- val
+			}
+		}(edges[input][name])
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		mwg.Wait()
+		close(in)
+	}()
+}
+
+// fanOut delivers items from out to the consumer links and closes them
+// when out is closed. Items of a node without consumers are drained so its
+// job never blocks. unknown counts items per route name that is not a
+// consumer.
+func fanOut(out chan interface{}, targets map[string]chan interface{}, route func(interface{}) []string, unknown map[string]int) {
+	for val := range out {
+		if route == nil {
+			for _, edge := range targets {
+				edge <- val
+			}
+			continue
+		}
+		for _, name := range route(val) {
+			if edge, ok := targets[name]; ok {
+				edge <- val
+			} else {
+				unknown[name]++
+			}
+		}
+	}
+	for _, edge := range targets {
+		close(edge)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func collectInts(mu *sync.Mutex, res *[]int) job {
+	return job(func(in, out chan interface{}) {
+		for val := range in {
+			mu.Lock()
+			*res = append(*res, val.(int))
+			mu.Unlock()
+		}
+	})
+}
+
+func TestGraphBroadcastAndMerge(t *testing.T) {
+	mu := &sync.Mutex{}
+	var evens, all, merged []int
+	err := ExecuteGraph(
+		Node{Name: "src", Job: produceInts(6)},
+		Node{Name: "double", Inputs: []string{"src"}, Job: job(func(in, out chan interface{}) {
+			for val := range in {
+				out <- val.(int) * 2
+			}
+		})},
+		Node{Name: "negate", Inputs: []string{"src"}, Job: job(func(in, out chan interface{}) {
+			for val := range in {
+				out <- -val.(int)
+			}
+		})},
+		Node{Name: "merge", Inputs: []string{"double", "negate"}, Job: collectInts(mu, &merged)},
+		Node{Name: "all", Inputs: []string{"src"}, Job: collectInts(mu, &all)},
+		Node{
+			Name: "router",
+			Job:  produceInts(6),
+			Route: func(val interface{}) []string {
+				if val.(int)%2 == 0 {
+					return []string{"evens"}
+				}
+				return nil
+			},
+		},
+		Node{Name: "evens", Inputs: []string{"router"}, Job: collectInts(mu, &evens)},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sort.Ints(merged)
+	expected := []int{-5, -4, -3, -2, -1, 0, 0, 2, 4, 6, 8, 10}
+	if !equalInts(merged, expected) {
+		t.Errorf("merge: got %v, expected %v", merged, expected)
+	}
+	if !equalInts(all, []int{0, 1, 2, 3, 4, 5}) {
+		t.Errorf("broadcast: got %v", all)
+	}
+	if !equalInts(evens, []int{0, 2, 4}) {
+		t.Errorf("route: got %v", evens)
+	}
+}
+
+func TestGraphValidation(t *testing.T) {
+	noop := job(func(in, out chan interface{}) {})
+	cases := [][]Node{
+		{{Name: "a", Job: noop, Inputs: []string{"missing"}}},
+		{{Name: "a", Job: noop}, {Name: "a", Job: noop}},
+		{{Name: "a", Job: noop, Inputs: []string{"b"}}, {Name: "b", Job: noop, Inputs: []string{"a"}}},
+		{{Name: "a"}},
+	}
+	for i, nodes := range cases {
+		if err := ExecuteGraph(nodes...); err == nil {
+			t.Errorf("[%d] expected error, got nil", i)
+		}
+	}
+}
+
+func TestGraphSlowConsumer(t *testing.T) {
+	var fastDone time.Duration
+	var slow []int
+	start := time.Now()
+	err := ExecuteGraph(
+		Node{Name: "src", Job: produceInts(20), Link: &LinkConfig{BufferSize: 20}},
+		Node{Name: "fast", Inputs: []string{"src"}, Job: job(func(in, out chan interface{}) {
+			for range in {
+			}
+			fastDone = time.Since(start)
+		})},
+		Node{Name: "slow", Inputs: []string{"src"}, Job: slowCollect(&slow, 10*time.Millisecond)},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(slow) != 20 {
+		t.Errorf("slow consumer got %d items", len(slow))
+	}
+	// медленный потребитель работает ~200ms, быстрый не должен его ждать
+	if fastDone > 100*time.Millisecond {
+		t.Errorf("fast consumer held back by the slow one: done after %s", fastDone)
+	}
+}
+
+func TestGraphBackpressure(t *testing.T) {
+	var produced, lag int64
+	src := job(func(in, out chan interface{}) {
+		for i := 0; i < 100; i++ {
+			out <- i
+			atomic.AddInt64(&produced, 1)
+		}
+	})
+	sink := job(func(in, out chan interface{}) {
+		received := int64(0)
+		for range in {
+			received++
+			if d := atomic.LoadInt64(&produced) - received; d > lag {
+				lag = d
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+	err := ExecuteGraph(
+		Node{Name: "src", Job: src, Link: &LinkConfig{BufferSize: 2}},
+		Node{Name: "sink", Inputs: []string{"src"}, Job: sink},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// буфер связи плюс по элементу в каналах и горутинах между узлами
+	if lag > 8 {
+		t.Errorf("producer ran %d items ahead of a slow consumer with a buffer of 2", lag)
+	}
+
+	dropping := &LinkConfig{BufferSize: 2, Policy: PolicyDropNewest}
+	err = ExecuteGraph(
+		Node{Name: "src", Job: produceInts(100), Link: dropping},
+		Node{Name: "sink", Inputs: []string{"src"}, Job: slowCollect(new([]int), time.Millisecond)},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if dropping.DroppedCount() == 0 {
+		t.Error("nothing dropped on a full link")
+	}
+}
+
+func TestGraphUnknownRoute(t *testing.T) {
+	mu := &sync.Mutex{}
+	var evens []int
+	err := ExecuteGraph(
+		Node{
+			Name: "router",
+			Job:  produceInts(6),
+			Route: func(val interface{}) []string {
+				if val.(int)%2 == 0 {
+					return []string{"evens"}
+				}
+				return []string{"odds"}
+			},
+		},
+		Node{Name: "evens", Inputs: []string{"router"}, Job: collectInts(mu, &evens)},
+	)
+	if err == nil || err.Error() != "node router routed 3 items to unknown consumer odds" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !equalInts(evens, []int{0, 2, 4}) {
+		t.Errorf("route: got %v", evens)
+	}
+}
+
+func TestGraphNoLeftoverGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	mu := &sync.Mutex{}
+	var res []int
+	err := ExecuteGraph(
+		Node{Name: "src", Job: produceInts(10), Link: &LinkConfig{BufferSize: 2, Policy: PolicyDropOldest}},
+		Node{Name: "a", Inputs: []string{"src"}, Job: collectInts(mu, &res)},
+		Node{Name: "b", Inputs: []string{"src"}, Job: collectInts(mu, &res)},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines left after ExecuteGraph", after-before)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
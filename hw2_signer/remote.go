@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Items travel between the coordinator and workers gob-encoded as
+// interface{} values, types other than builtin ones have to be registered
+// with gob.Register on both sides.
+//
+// The coordinator dials every worker once and keeps the connections for
+// all runs. Each item is a request with its own ID, the worker runs the
+// stage for it separately and answers with everything the stage emitted,
+// so results can come back in any order. Requests carry the coordinator's
+// DataSignerSalt and a worker with another salt refuses them, the signers
+// would produce different hashes there.
+
+// remoteRequest is one item sent to a worker.
+type remoteRequest struct {
+	ID   uint64
+	Salt string
+	Val  interface{}
+}
+
+// remoteResponse carries the stage output for the request with the same ID
+// or the reason the worker refused it.
+type remoteResponse struct {
+	ID   uint64
+	Vals []interface{}
+	Err  string
+}
+
+// ServeStage accepts connections on l and runs stage for each item
+// received on them. Items sent with a salt other than DataSignerSalt are
+// refused. It returns when Accept fails, e.g. after l is closed.
+func ServeStage(l net.Listener, stage job) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go serveStageConn(conn, stage)
+	}
+}
+
+func serveStageConn(conn net.Conn, stage job) {
+	defer conn.Close()
+	w := bufio.NewWriter(conn)
+	enc := gob.NewEncoder(w)
+	mu := &sync.Mutex{}
+	var err error
+	wg := &sync.WaitGroup{}
+	dec := gob.NewDecoder(bufio.NewReader(conn))
+	for {
+		req := remoteRequest{}
+		if dec.Decode(&req) != nil {
+			break
+		}
+		wg.Add(1)
+		go func(req remoteRequest) {
+			defer wg.Done()
+			resp := remoteResponse{ID: req.ID}
+			if req.Salt != DataSignerSalt {
+				resp.Err = "salt does not match the worker's"
+			} else {
+				resp.Vals = runSingle(stage, req.Val)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				err = enc.Encode(&resp)
+			}
+			if err == nil {
+				err = w.Flush()
+			}
+		}(req)
+	}
+	wg.Wait()
+}
+
+// RemoteStage is a job hosted by worker processes started with ServeStage.
+// Workers are dialed on first use, Close drops the connections.
+type RemoteStage struct {
+	Network string
+	Addrs   []string
+	// Salt is sent with every item, workers refuse it unless it is their
+	// DataSignerSalt
+	Salt string
+
+	mu      sync.Mutex
+	err     error
+	connErr error
+	dialed  bool
+	conns   []*remoteConn
+	next    int
+	lastID  uint64
+}
+
+// NewRemoteStage returns a stage spreading items over workers at addrs
+// with the current DataSignerSalt.
+func NewRemoteStage(network string, addrs ...string) *RemoteStage {
+	return &RemoteStage{Network: network, Addrs: addrs, Salt: DataSignerSalt}
+}
+
+// Err returns the first error that left an item unprocessed. Items of a
+// failed worker are sent to the others, so this only happens when no
+// worker is left.
+func (rs *RemoteStage) Err() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.err
+}
+
+func (rs *RemoteStage) setErr(err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.err == nil {
+		rs.err = err
+	}
+}
+
+// Close closes connections to the workers, the next run dials them again.
+func (rs *RemoteStage) Close() {
+	rs.mu.Lock()
+	conns := rs.conns
+	rs.conns = nil
+	rs.dialed = false
+	rs.mu.Unlock()
+	for _, rc := range conns {
+		rs.fail(rc, fmt.Errorf("worker %s: closed", rc.addr))
+	}
+}
+
+// remoteConn is a connection to one worker. pending and broken are
+// guarded by RemoteStage.mu, writes by wmu.
+type remoteConn struct {
+	addr    string
+	conn    net.Conn
+	wmu     sync.Mutex
+	w       *bufio.Writer
+	enc     *gob.Encoder
+	pending map[uint64]chan []interface{}
+	broken  bool
+}
+
+func (rc *remoteConn) send(req *remoteRequest) error {
+	rc.wmu.Lock()
+	defer rc.wmu.Unlock()
+	if err := rc.enc.Encode(req); err != nil {
+		return err
+	}
+	return rc.w.Flush()
+}
+
+// Job returns the stage as a job for ExecutePipeline. Items are sent to
+// workers round robin, continuing from where the previous run stopped.
+func (rs *RemoteStage) Job() job {
+	return job(rs.run)
+}
+
+func (rs *RemoteStage) run(in, out chan interface{}) {
+	wg := &sync.WaitGroup{}
+	for val := range in {
+		wg.Add(1)
+		go func(val interface{}) {
+			defer wg.Done()
+			vals, err := rs.call(val)
+			if err != nil {
+				rs.setErr(err)
+				return
+			}
+			for _, v := range vals {
+				out <- v
+			}
+		}(val)
+	}
+	wg.Wait()
+}
+
+// call sends val to a worker and waits for its results. If the worker
+// fails first, val goes to the next one.
+func (rs *RemoteStage) call(val interface{}) ([]interface{}, error) {
+	for {
+		rc, id, done, err := rs.pick()
+		if err != nil {
+			return nil, err
+		}
+		if err := rc.send(&remoteRequest{ID: id, Salt: rs.Salt, Val: val}); err != nil {
+			rs.fail(rc, fmt.Errorf("worker %s: %s", rc.addr, err))
+			continue
+		}
+		// канал закрыт, если воркер упал до ответа
+		if vals, ok := <-done; ok {
+			return vals, nil
+		}
+	}
+}
+
+// pick returns the next healthy connection with a request registered on it.
+func (rs *RemoteStage) pick() (*remoteConn, uint64, chan []interface{}, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if !rs.dialed {
+		rs.dial()
+	}
+	for i := 0; i < len(rs.conns); i++ {
+		rc := rs.conns[rs.next%len(rs.conns)]
+		rs.next++
+		if rc.broken {
+			continue
+		}
+		rs.lastID++
+		done := make(chan []interface{}, 1)
+		rc.pending[rs.lastID] = done
+		return rc, rs.lastID, done, nil
+	}
+	if rs.connErr != nil {
+		return nil, 0, nil, fmt.Errorf("no workers left, last error: %s", rs.connErr)
+	}
+	return nil, 0, nil, fmt.Errorf("no workers")
+}
+
+// dial connects to all workers, it is called with rs.mu held.
+func (rs *RemoteStage) dial() {
+	rs.dialed = true
+	for _, addr := range rs.Addrs {
+		conn, err := net.Dial(rs.Network, addr)
+		if err != nil {
+			rs.connErr = fmt.Errorf("worker %s: %s", addr, err)
+			continue
+		}
+		w := bufio.NewWriter(conn)
+		rc := &remoteConn{
+			addr:    addr,
+			conn:    conn,
+			w:       w,
+			enc:     gob.NewEncoder(w),
+			pending: make(map[uint64]chan []interface{}),
+		}
+		rs.conns = append(rs.conns, rc)
+		go rs.receive(rc)
+	}
+}
+
+// receive hands responses of rc to the waiting calls until rc fails. A
+// refused request fails rc too, the worker would refuse the others as well.
+func (rs *RemoteStage) receive(rc *remoteConn) {
+	dec := gob.NewDecoder(bufio.NewReader(rc.conn))
+	for {
+		resp := remoteResponse{}
+		if err := dec.Decode(&resp); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			rs.fail(rc, fmt.Errorf("worker %s: %s", rc.addr, err))
+			return
+		}
+		if resp.Err != "" {
+			rs.fail(rc, fmt.Errorf("worker %s: %s", rc.addr, resp.Err))
+			return
+		}
+		rs.mu.Lock()
+		if done, ok := rc.pending[resp.ID]; ok {
+			delete(rc.pending, resp.ID)
+			done <- resp.Vals
+		}
+		rs.mu.Unlock()
+	}
+}
+
+// fail marks rc broken and wakes up its calls so they retry elsewhere.
+func (rs *RemoteStage) fail(rc *remoteConn, err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rc.broken {
+		return
+	}
+	rc.broken = true
+	rc.conn.Close()
+	rs.connErr = err
+	for id, done := range rc.pending {
+		delete(rc.pending, id)
+		close(done)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+var double = job(func(in, out chan interface{}) {
+	for val := range in {
+		out <- val.(int) * 2
+	}
+})
+
+func collectRemote(remote *RemoteStage, n int) []int {
+	var res []int
+	ExecutePipeline(
+		produceInts(n),
+		remote.Job(),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				res = append(res, val.(int))
+			}
+		}),
+	)
+	sort.Ints(res)
+	return res
+}
+
+func startWorkers(t *testing.T, network string, addrs []string, stage job) []string {
+	res := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			t.Fatalf("cant listen: %s", err)
+		}
+		t.Cleanup(func() { l.Close() })
+		go ServeStage(l, stage)
+		res = append(res, l.Addr().String())
+	}
+	return res
+}
+
+func TestRemoteStage(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		network string
+		addrs   []string
+	}{
+		{"tcp", []string{"127.0.0.1:0", "127.0.0.1:0", "127.0.0.1:0"}},
+		{"unix", []string{filepath.Join(dir, "w1.sock"), filepath.Join(dir, "w2.sock")}},
+	}
+	for _, c := range cases {
+		remote := NewRemoteStage(c.network, startWorkers(t, c.network, c.addrs, double)...)
+		res := collectRemote(remote, 20)
+		remote.Close()
+		if err := remote.Err(); err != nil {
+			t.Fatalf("[%s] unexpected error: %s", c.network, err)
+		}
+		if len(res) != 20 {
+			t.Fatalf("[%s] expected 20 results, got %d", c.network, len(res))
+		}
+		for i, val := range res {
+			if val != i*2 {
+				t.Errorf("[%s] wrong result %d at %d", c.network, val, i)
+			}
+		}
+	}
+}
+
+func TestRemoteStageNoWorkers(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	remote := NewRemoteStage("tcp", addr)
+	ExecutePipeline(produceInts(3), remote.Job())
+	if remote.Err() == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+// countingListener counts accepted connections.
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return conn, err
+}
+
+func TestRemoteStageDialsOnce(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &countingListener{Listener: inner}
+	defer l.Close()
+	go ServeStage(l, double)
+
+	remote := NewRemoteStage("tcp", l.Addr().String())
+	defer remote.Close()
+	for i := 0; i < 5; i++ {
+		ExecutePipeline(produceInts(1), remote.Job())
+		collectRemote(remote, 3)
+	}
+	if err := remote.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n := atomic.LoadInt32(&l.accepted); n != 1 {
+		t.Errorf("worker accepted %d connections, want 1", n)
+	}
+}
+
+func TestRemoteStageWorkerFails(t *testing.T) {
+	// этот воркер читает запросы и рвет соединение, не ответив
+	broken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broken.Close()
+	go func() {
+		for {
+			conn, err := broken.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 64)
+			conn.Read(buf)
+			conn.Close()
+		}
+	}()
+
+	addrs := append([]string{broken.Addr().String()}, startWorkers(t, "tcp", []string{"127.0.0.1:0"}, double)...)
+	remote := NewRemoteStage("tcp", addrs...)
+	defer remote.Close()
+	res := collectRemote(remote, 20)
+	if err := remote.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(res) != 20 {
+		t.Fatalf("expected 20 results, got %d", len(res))
+	}
+	for i, val := range res {
+		if val != i*2 {
+			t.Errorf("wrong result %d at %d", val, i)
+		}
+	}
+}
+
+func TestRemoteStageSaltMismatch(t *testing.T) {
+	remote := NewRemoteStage("tcp", startWorkers(t, "tcp", []string{"127.0.0.1:0"}, double)...)
+	defer remote.Close()
+	remote.Salt = DataSignerSalt + "other"
+	if res := collectRemote(remote, 3); len(res) != 0 {
+		t.Errorf("worker with another salt returned %v", res)
+	}
+	if err := remote.Err(); err == nil || !strings.Contains(err.Error(), "salt") {
+		t.Errorf("expected salt error, got %v", err)
+	}
+}
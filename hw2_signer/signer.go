@@ -6,7 +6,6 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 )
 
 type Crc32Res struct {
@@ -14,11 +13,15 @@ type Crc32Res struct {
 	crc32Str string
 }
 
+// md5Mu serialises DataSignerMd5 calls across all SingleHash invocations,
+// parallel calls overheat the signer
+var md5Mu = &sync.Mutex{}
+
 func SingleHash(in, out chan interface{}) {
-	mu := &sync.Mutex{}
+	mu := md5Mu
 	wg := &sync.WaitGroup{}
 	for i := range in {
-		strval := strconv.Itoa(i.(int))
+		strval := dataString(i)
 		wg.Add(1)
 		go func(val interface{}, mymu *sync.Mutex, mywg *sync.WaitGroup) {
 			defer wg.Done()
@@ -58,6 +61,18 @@ func SingleHash(in, out chan interface{}) {
 // 	}
 // }
 
+// dataString converts a pipeline input into the string that gets signed.
+func dataString(val interface{}) string {
+	switch v := val.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
 func myCrc32(strval *string, mych chan *Crc32Res, mywg *sync.WaitGroup, i int) {
 	defer mywg.Done()
 	mych <- &Crc32Res{index: i, crc32Str: DataSignerCrc32(*strval)}
@@ -104,43 +119,6 @@ func CombineResults(in, out chan interface{}) {
 	out <- result
 }
 
-// сюда писать код
-func main() {
-	freeFlowJobs := []job{
-		job(func(in, out chan interface{}) {
-			out <- int(0)
-			out <- int(1)
-			out <- int(1)
-			out <- int(2)
-			out <- int(3)
-			out <- int(5)
-			out <- int(8)
-		}),
-		job(SingleHash),
-		job(MultiHash),
-		job(CombineResults),
-		job(func(in, out chan interface{}) {
-			for val := range in {
-				fmt.Println("collected", val)
-			}
-
-		}),
-	}
-
-	start := time.Now()
-
-	ExecutePipeline(freeFlowJobs...)
-
-	end := time.Since(start)
-	fmt.Println("Exec  time:", end)
-	expectedTime := time.Millisecond * 3000
-
-	if end > expectedTime {
-		fmt.Printf("execition too long\nGot: %s\nExpected: <%s", end, expectedTime)
-	}
-
-}
-
 func ExecutePipeline(freeFlowJobs ...job) {
 	var nextIn chan interface{} = make(chan interface{}, 1)
 	wg := &sync.WaitGroup{}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/mailru/easyjson/jlexer"
+)
+
+// GroupOptions configure GroupBy.
+type GroupOptions struct {
+	// Field is company, country or job
+	Field string
+	// Query selects users to aggregate, nil means all of them
+	Query *Query
+	// MaxGroups bounds memory: at most that many groups are tracked and
+	// the rarest one is evicted for a new value (space-saving), so counts
+	// of the top groups stay accurate while the tail becomes approximate.
+	// Zero means 10000.
+	MaxGroups int
+	Tolerant  bool
+}
+
+// Group is an aggregate of users with the same field value.
+type Group struct {
+	Key   string `json:"key"`
+	Users int    `json:"users"`
+	// Error is the maximum overestimate of Users caused by evictions
+	Error int `json:"error"`
+	// Browsers is the number of distinct browsers of the group's users,
+	// estimated once it exceeds sketchSize or the group replaced an evicted one
+	Browsers int  `json:"distinct_browsers"`
+	Exact    bool `json:"exact"`
+
+	sketch *distinctSketch
+	pos    int
+}
+
+// Aggregation is the result of GroupBy.
+type Aggregation struct {
+	Field string `json:"field"`
+	// Users is the number of aggregated users
+	Users int `json:"users"`
+	// Evicted is the number of times a group was dropped to make room,
+	// if it is zero all counts are exact
+	Evicted int      `json:"evicted"`
+	Groups  []*Group `json:"groups"`
+}
+
+// GroupBy reads a possibly compressed dataset in one pass and groups
+// users by opts.Field. Groups are sorted by users descending, then by key.
+func GroupBy(r io.Reader, opts GroupOptions) (*Aggregation, *SearchReport, error) {
+	field, ok := queryFields[opts.Field]
+	if !ok || field == fieldBrowsers || field == fieldName || field == fieldEmail || field == fieldPhone {
+		return nil, nil, fmt.Errorf("can not group by %q, want company, country or job", opts.Field)
+	}
+	reader, release, err := NewDatasetReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	fields := field | fieldBrowsers
+	var leaves []bool
+	if opts.Query != nil {
+		fields |= opts.Query.fields
+		leaves = make([]bool, len(opts.Query.leaves))
+	}
+	groups := newGroupTable(opts.MaxGroups)
+	agg := &Aggregation{Field: opts.Field}
+	report := &SearchReport{}
+	user := &User{}
+	var scratch []byte
+	for i := 0; ; i++ {
+		line, err := readLine(reader, &scratch)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, report, err
+		}
+		report.Lines++
+		lexer := jlexer.Lexer{Data: line}
+		decodeUserFields(&lexer, user, fields)
+		if err := lexer.Error(); err != nil {
+			if lerr := report.skip(i, err, opts.Tolerant); lerr != nil {
+				return nil, report, lerr
+			}
+			continue
+		}
+		if opts.Query != nil && !opts.Query.match(user, leaves, nil) {
+			continue
+		}
+		report.Matched++
+		agg.Users++
+		g := groups.add(field.get(user))
+		for _, browser := range user.Browsers {
+			g.sketch.add(browser)
+		}
+	}
+
+	agg.Evicted = groups.evicted
+	agg.Groups = groups.groups
+	for _, g := range agg.Groups {
+		g.Browsers, g.Exact = g.sketch.estimate()
+		// после вытеснения браузеры до него потеряны
+		g.Exact = g.Exact && g.Error == 0
+	}
+	sort.Slice(agg.Groups, func(i, j int) bool {
+		a, b := agg.Groups[i], agg.Groups[j]
+		if a.Users != b.Users {
+			return a.Users > b.Users
+		}
+		return a.Key < b.Key
+	})
+	return agg, report, nil
+}
+
+// Top returns the first n groups, all of them if n < 1.
+func (a *Aggregation) Top(n int) []*Group {
+	if n < 1 || n > len(a.Groups) {
+		return a.Groups
+	}
+	return a.Groups[:n]
+}
+
+// WriteCSV prints the top n groups, one per row.
+func (a *Aggregation) WriteCSV(w io.Writer, n int) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{a.Field, "users", "error", "distinct_browsers", "exact"})
+	for _, g := range a.Top(n) {
+		cw.Write([]string{g.Key, strconv.Itoa(g.Users), strconv.Itoa(g.Error), strconv.Itoa(g.Browsers), strconv.FormatBool(g.Exact)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON prints the aggregation with the top n groups.
+func (a *Aggregation) WriteJSON(w io.Writer, n int) error {
+	res := *a
+	res.Groups = a.Top(n)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+// groupTable is a space-saving counter: a map of groups plus a min-heap
+// by users to find the group to evict.
+type groupTable struct {
+	max     int
+	byKey   map[string]*Group
+	groups  []*Group
+	evicted int
+}
+
+func newGroupTable(max int) *groupTable {
+	if max < 1 {
+		max = 10000
+	}
+	return &groupTable{max: max, byKey: make(map[string]*Group)}
+}
+
+func (t *groupTable) add(key string) *Group {
+	if g, ok := t.byKey[key]; ok {
+		g.Users++
+		heap.Fix(t, g.pos)
+		return g
+	}
+	if len(t.groups) < t.max {
+		g := &Group{Key: key, Users: 1, sketch: &distinctSketch{}}
+		t.byKey[key] = g
+		heap.Push(t, g)
+		return g
+	}
+	// вытесняем самую редкую группу, новая наследует ее счетчик как ошибку
+	g := t.groups[0]
+	delete(t.byKey, g.Key)
+	t.evicted++
+	g.Key = key
+	g.Error = g.Users
+	g.Users++
+	g.sketch = &distinctSketch{}
+	t.byKey[key] = g
+	heap.Fix(t, 0)
+	return g
+}
+
+func (t *groupTable) Len() int           { return len(t.groups) }
+func (t *groupTable) Less(i, j int) bool { return t.groups[i].Users < t.groups[j].Users }
+
+func (t *groupTable) Swap(i, j int) {
+	t.groups[i], t.groups[j] = t.groups[j], t.groups[i]
+	t.groups[i].pos = i
+	t.groups[j].pos = j
+}
+
+func (t *groupTable) Push(x interface{}) {
+	g := x.(*Group)
+	g.pos = len(t.groups)
+	t.groups = append(t.groups, g)
+}
+
+func (t *groupTable) Pop() interface{} {
+	g := t.groups[len(t.groups)-1]
+	t.groups = t.groups[:len(t.groups)-1]
+	return g
+}
+
+// sketchSize is how many hashes a distinctSketch keeps.
+const sketchSize = 256
+
+// distinctSketch counts distinct strings in constant memory by keeping
+// the sketchSize smallest hashes (k minimum values). Below sketchSize
+// distinct values the count is exact.
+type distinctSketch struct {
+	// hashes is sorted ascending
+	hashes []uint64
+}
+
+func (s *distinctSketch) add(value string) {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	x := mix64(h.Sum64())
+	n := len(s.hashes)
+	if n == sketchSize && x >= s.hashes[n-1] {
+		return
+	}
+	i := sort.Search(n, func(i int) bool { return s.hashes[i] >= x })
+	if i < n && s.hashes[i] == x {
+		return
+	}
+	if n < sketchSize {
+		s.hashes = append(s.hashes, 0)
+	}
+	copy(s.hashes[i+1:], s.hashes[i:])
+	s.hashes[i] = x
+}
+
+func (s *distinctSketch) estimate() (int, bool) {
+	n := len(s.hashes)
+	if n < sketchSize {
+		return n, true
+	}
+	// k-й минимум из равномерных на [0, 2^64) значений ~ k/N * 2^64
+	frac := float64(s.hashes[n-1]) / (1 << 64)
+	return int(float64(n-1)/frac + 0.5), false
+}
+
+// mix64 is the splitmix64 finalizer, fnv alone spreads short strings poorly.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
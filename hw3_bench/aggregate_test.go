@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGroupBy(t *testing.T) {
+	data := strings.Join([]string{
+		`{"browsers":["Android 4","MSIE 6"],"country":"Peru","job":"Nurse"}`,
+		`{"browsers":["Android 4","Opera"],"country":"Peru","job":"Pilot"}`,
+		`{"browsers":["MSIE 7"],"country":"Chad","job":"Nurse"}`,
+		`{"browsers":[],"country":"Peru"}`,
+	}, "\n")
+	agg, report, err := GroupBy(strings.NewReader(data), GroupOptions{Field: "country"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agg.Users != 4 || report.Lines != 4 || agg.Evicted != 0 || len(agg.Groups) != 2 {
+		t.Fatalf("wrong aggregation: %+v", agg)
+	}
+	peru := agg.Groups[0]
+	if peru.Key != "Peru" || peru.Users != 3 || peru.Browsers != 3 || !peru.Exact {
+		t.Errorf("wrong group: %+v", peru)
+	}
+
+	out := new(bytes.Buffer)
+	if err := agg.WriteCSV(out, 1); err != nil {
+		t.Fatal(err)
+	}
+	expected := "country,users,error,distinct_browsers,exact\nPeru,3,0,3,true\n"
+	if out.String() != expected {
+		t.Errorf("unexpected csv\nGot:\n%s\nExpected:\n%s", out, expected)
+	}
+
+	q := MustCompileQuery("browsers~MSIE")
+	agg, _, err = GroupBy(strings.NewReader(data), GroupOptions{Field: "job", Query: q})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agg.Users != 2 || len(agg.Groups) != 1 || agg.Groups[0].Key != "Nurse" || agg.Groups[0].Browsers != 3 {
+		t.Errorf("wrong filtered aggregation: %+v %+v", agg, agg.Groups[0])
+	}
+
+	if _, _, err := GroupBy(strings.NewReader(data), GroupOptions{Field: "email"}); err == nil {
+		t.Error("expected error for email field")
+	}
+}
+
+func TestGroupByBounded(t *testing.T) {
+	lines := []string{}
+	// частые компании вперемешку с длинным хвостом уникальных
+	for i := 0; i < 1000; i++ {
+		company := fmt.Sprintf("tail%d", i)
+		switch {
+		case i%2 == 0:
+			company = "big"
+		case i%5 == 1:
+			company = "medium"
+		}
+		lines = append(lines, fmt.Sprintf(`{"browsers":["b%d"],"company":%q}`, i%7, company))
+	}
+	agg, _, err := GroupBy(strings.NewReader(strings.Join(lines, "\n")), GroupOptions{Field: "company", MaxGroups: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agg.Groups) != 10 || agg.Evicted == 0 || agg.Users != 1000 {
+		t.Fatalf("wrong aggregation: %d groups, %d evicted", len(agg.Groups), agg.Evicted)
+	}
+	top := agg.Top(2)
+	if top[0].Key != "big" || top[0].Users != 500 || top[0].Error != 0 || !top[0].Exact || top[0].Browsers != 7 {
+		t.Errorf("wrong top group: %+v", top[0])
+	}
+	if top[1].Key != "medium" || top[1].Users-top[1].Error > 100 || top[1].Users < 100 {
+		t.Errorf("wrong second group: %+v", top[1])
+	}
+}
+
+func TestDistinctSketch(t *testing.T) {
+	s := &distinctSketch{}
+	for i := 0; i < 100; i++ {
+		s.add(fmt.Sprint(i % 50))
+	}
+	if n, exact := s.estimate(); n != 50 || !exact {
+		t.Errorf("expected exact 50, got %d %v", n, exact)
+	}
+	for i := 0; i < 100000; i++ {
+		s.add(fmt.Sprint(i))
+	}
+	n, exact := s.estimate()
+	if exact || n < 85000 || n > 115000 {
+		t.Errorf("estimate %d (exact %v) too far from 100000", n, exact)
+	}
+	if len(s.hashes) != sketchSize {
+		t.Errorf("sketch grew to %d hashes", len(s.hashes))
+	}
+}
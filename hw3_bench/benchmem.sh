@@ -1,3 +1,3 @@
 #!/bin/bash
 
-go test -bench . -benchmem -cpuprofile=cpu.out -memprofile=mem.out -memprofilerate=1 main_test.go common.go fast.go
\ No newline at end of file
+go test -bench . -benchmem -cpuprofile=cpu.out -memprofile=mem.out -memprofilerate=1 *.go
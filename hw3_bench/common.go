@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -11,17 +12,30 @@ import (
 	// "log"
 )
 
-const filePath string = "./data/users.txt"
+// filePath is a variable so tests can point searches at other datasets
+var filePath = "./data/users.txt"
 
-func SlowSearch(out io.Writer) {
-	file, err := os.Open(filePath)
+func SlowSearch(out io.Writer) error {
+	_, err := SlowSearchReport(out, false)
+	return err
+}
+
+// SlowSearchReport is SlowSearch that can skip malformed lines.
+func SlowSearchReport(out io.Writer, tolerant bool) (*SearchReport, error) {
+	return SlowSearchFile(out, filePath, tolerant)
+}
+
+// SlowSearchFile is SlowSearchReport over the dataset at path.
+func SlowSearchFile(out io.Writer, path string, tolerant bool) (*SearchReport, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	defer file.Close()
 
 	fileContents, err := ioutil.ReadAll(file)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	r := regexp.MustCompile("@")
@@ -31,13 +45,21 @@ func SlowSearch(out io.Writer) {
 
 	lines := strings.Split(string(fileContents), "\n")
 
+	report := &SearchReport{Lines: len(lines)}
 	users := make([]map[string]interface{}, 0)
-	for _, line := range lines {
+	for i, line := range lines {
 		user := make(map[string]interface{})
 		// fmt.Printf("%v %v\n", err, line)
 		err := json.Unmarshal([]byte(line), &user)
+		if err == nil {
+			err = checkUser(user)
+		}
 		if err != nil {
-			panic(err)
+			if lerr := report.skip(i, err, tolerant); lerr != nil {
+				return report, lerr
+			}
+			// пустой пользователь, чтобы не сбить нумерацию
+			user = nil
 		}
 		users = append(users, user)
 	}
@@ -102,10 +124,44 @@ func SlowSearch(out io.Writer) {
 		}
 
 		// log.Println("Android and MSIE user:", user["name"], user["email"])
-		email := r.ReplaceAllString(user["email"].(string), " [at] ")
-		foundUsers += fmt.Sprintf("[%d] %s <%s>\n", i, user["name"], email)
+		report.Matched++
+		name, _ := user["name"].(string)
+		email, _ := user["email"].(string)
+		email = r.ReplaceAllString(email, " [at] ")
+		foundUsers += fmt.Sprintf("[%d] %s <%s>\n", i, name, email)
 	}
 
 	fmt.Fprintln(out, "found users:\n"+foundUsers)
 	fmt.Fprintln(out, "Total unique browsers", len(seenBrowsers))
+	return report, nil
+}
+
+// checkUser rejects a decoded line the fast decoder fails on: not an
+// object, or name, email or browsers of the wrong type. Missing and null
+// fields are left empty, as in FastSearch.
+func checkUser(user map[string]interface{}) error {
+	if user == nil {
+		return errors.New("not an object")
+	}
+	for _, key := range []string{"name", "email"} {
+		if v := user[key]; v != nil {
+			if _, ok := v.(string); !ok {
+				return fmt.Errorf("%s: expected string, got %v", key, v)
+			}
+		}
+	}
+	v := user["browsers"]
+	if v == nil {
+		return nil
+	}
+	browsers, ok := v.([]interface{})
+	if !ok {
+		return fmt.Errorf("browsers: expected array, got %v", v)
+	}
+	for _, browser := range browsers {
+		if _, ok := browser.(string); !ok {
+			return fmt.Errorf("browsers: expected string, got %v", browser)
+		}
+	}
+	return nil
 }
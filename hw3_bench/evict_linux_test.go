@@ -0,0 +1,28 @@
+//go:build linux && (amd64 || arm64)
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+const fadvDontNeed = 4 // POSIX_FADV_DONTNEED
+
+// evictFile drops cached pages of path, the next read goes to the disk.
+func evictFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// грязные страницы DONTNEED не выбрасывает, сначала сбрасываем на диск
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, file.Fd(), 0, 0, fadvDontNeed, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
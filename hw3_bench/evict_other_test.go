@@ -0,0 +1,12 @@
+//go:build !linux || !(amd64 || arm64)
+
+package main
+
+import (
+	"errors"
+)
+
+// evictFile needs posix_fadvise, only wired up for Linux on 64-bit.
+func evictFile(path string) error {
+	return errors.New("evicting files from the page cache is not supported")
+}
@@ -1,13 +1,12 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"os"
-	"regexp"
+	"strings"
 
 	"github.com/mailru/easyjson"
 	"github.com/mailru/easyjson/jlexer"
@@ -25,66 +24,365 @@ type User struct {
 }
 
 func main() {
-	fastOut := new(bytes.Buffer)
-	FastSearch(fastOut)
-	fastResult := fastOut.String()
-	fmt.Println(fastResult)
+	query := flag.String("q", defaultQuery.String(), "search query, see CompileQuery")
+	path := flag.String("f", filePath, "dataset, may be gzip compressed, or zstd in builds with -tags zstd")
+	indexPath := flag.String("index", "", "use (and update) an inverted index stored at this path, plain datasets only")
+	mmap := flag.Bool("mmap", false, "scan the memory-mapped file in place, Linux only, others fall back to reading; the file must not be truncated meanwhile")
+	tolerant := flag.Bool("tolerant", false, "skip malformed lines and print a summary to stderr")
+	reportFormat := flag.String("report", "", "print browser statistics of the whole dataset instead of searching: text, csv or json")
+	top := flag.Int("top", 10, "entries per report histogram or groups to print, 0 for all")
+	groupField := flag.String("group", "", "aggregate users by company, country or job instead of searching, -q filters users only if given")
+	groupFormat := flag.String("format", "csv", "aggregation output: csv or json")
+	maxGroups := flag.Int("max-groups", 10000, "groups tracked exactly during aggregation, rarer ones become approximate")
+	generate := flag.String("generate", "", "write a synthetic dataset of that many lines (k and M suffixes allowed) to -o and exit")
+	output := flag.String("o", "users_generated.txt", "generated dataset path")
+	seed := flag.Int64("seed", 1, "generator seed")
+	android := flag.Float64("android", 0.13, "share of Android browsers in generated data")
+	msie := flag.Float64("msie", 0.07, "share of MSIE browsers in generated data")
+	pool := flag.String("pool", "", "take generated browsers from this dataset instead of the built-in list")
+	bench := flag.String("bench", "", "comma separated dataset sizes to benchmark searches on, e.g. 10k,100k,1M")
+	variants := flag.String("variants", "", "comma separated searches to benchmark: Slow,Fast,Parallel,Scan,Mmap,Index (default all)")
+	benchTime := flag.String("benchtime", "", "run each benchmark for this long, like go test -benchtime")
+	redactName := flag.String("redact-name", "keep", "name policy: keep, mask, hash or drop")
+	redactEmail := flag.String("redact-email", "obfuscate", "email policy: keep, obfuscate, mask, hash or drop")
+	redactPhone := flag.String("redact-phone", "drop", "phone policy: keep, mask, hash or drop, phones are printed unless dropped")
+	salt := flag.String("salt", "", "salt for the hash redaction policy, required if it is used")
+	serve := flag.String("serve", "", "serve GET /search on this address, e.g. :8080")
+	flag.Parse()
+	redactor, err := newRedactor(*redactName, *redactEmail, *redactPhone, *salt)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *serve != "" {
+		if err := serveSearch(*serve, *path, redactor); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *generate != "" || *bench != "" {
+		genOpts := DefaultGenerateOptions(0, *seed)
+		genOpts.AndroidShare, genOpts.MSIEShare = *android, *msie
+		err := generateMode(genOpts, *pool, *generate, *output, *bench, *variants, *benchTime)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *groupField != "" {
+		opts := GroupOptions{Field: *groupField, MaxGroups: *maxGroups, Tolerant: *tolerant}
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "q" {
+				opts.Query, err = CompileQuery(*query)
+			}
+		})
+		if err == nil {
+			err = groupReport(os.Stdout, *path, opts, *groupFormat, *top)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *reportFormat != "" {
+		if err := browserReport(os.Stdout, *path, *reportFormat, *top, *tolerant); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	q, err := CompileQuery(*query)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	opts := SearchOptions{Query: q, Tolerant: *tolerant}
+	if *redactor != DefaultRedactor {
+		opts.Format, opts.Fields = redactor.Format, redactor.Fields()
+	}
+	var report *SearchReport
+	if *indexPath != "" {
+		var idx *Index
+		if idx, err = OpenIndex(*indexPath, *path); err == nil {
+			report, err = IndexSearch(os.Stdout, idx, opts)
+		}
+	} else if *mmap {
+		report, err = MmapSearch(os.Stdout, *path, opts)
+	} else {
+		report, err = SearchFile(os.Stdout, *path, opts)
+	}
+	if report != nil && len(report.Skipped) > 0 {
+		report.WriteSummary(os.Stderr)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
-// вам надо написать более быструю оптимальную этой функции
-func FastSearch(out io.Writer) {
-	file, err := os.Open(filePath)
+func browserReport(out io.Writer, path, format string, top int, tolerant bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stats, report, err := CollectBrowserStats(file, tolerant)
+	if report != nil && len(report.Skipped) > 0 {
+		report.WriteSummary(os.Stderr)
+	}
+	if err != nil {
+		return err
+	}
+	return stats.WriteReport(out, format, top)
+}
+
+func newRedactor(name, email, phone, salt string) (*Redactor, error) {
+	r := &Redactor{Salt: salt}
+	var err error
+	if r.Name, err = ParseRedactPolicy(name); err != nil {
+		return nil, err
+	}
+	if r.Email, err = ParseRedactPolicy(email); err != nil {
+		return nil, err
+	}
+	if r.Phone, err = ParseRedactPolicy(phone); err != nil {
+		return nil, err
+	}
+	// без соли хеш email или телефона восстанавливается перебором
+	if salt == "" && (r.Name == RedactHash || r.Email == RedactHash || r.Phone == RedactHash) {
+		return nil, fmt.Errorf("the hash redaction policy needs a non-empty -salt")
+	}
+	return r, nil
+}
+
+func generateMode(opts GenerateOptions, poolPath, generate, output, bench, variants, benchTime string) error {
+	if poolPath != "" {
+		file, err := os.Open(poolPath)
+		if err != nil {
+			return err
+		}
+		opts.Pool, err = BrowserPoolFrom(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if generate != "" {
+		lines, err := ParseCount(generate)
+		if err != nil {
+			return err
+		}
+		return generateFile(output, lines, opts)
+	}
+	benchOpts := BenchOptions{Generate: opts, BenchTime: benchTime}
+	for _, size := range strings.Split(bench, ",") {
+		lines, err := ParseCount(size)
+		if err != nil {
+			return err
+		}
+		benchOpts.Sizes = append(benchOpts.Sizes, lines)
+	}
+	if variants != "" {
+		benchOpts.Variants = strings.Split(variants, ",")
+	}
+	_, err := RunBenchmarks(os.Stdout, benchOpts)
+	return err
+}
+
+func groupReport(out io.Writer, path string, opts GroupOptions, format string, top int) error {
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("unknown format %q, want csv or json", format)
+	}
+	file, err := os.Open(path)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer file.Close()
-	reader := bufio.NewReader(file)
-	// lines := strings.Split(string(fileContents), "\n")
-
-	r := regexp.MustCompile("@")
-	androidRe := regexp.MustCompile("Android")
-	msieRe := regexp.MustCompile("MSIE")
-	seenBrowsers := make(map[string]bool)
-	user := &User{}
+	agg, report, err := GroupBy(file, opts)
+	if report != nil && len(report.Skipped) > 0 {
+		report.WriteSummary(os.Stderr)
+	}
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		return agg.WriteJSON(out, top)
+	}
+	return agg.WriteCSV(out, top)
+}
+
+// defaultQuery is what FastSearch looks for
+var defaultQuery = MustCompileQuery("browsers~Android AND browsers~MSIE")
+
+// ResultFormatter writes a single found user, index is the line number.
+type ResultFormatter func(out io.Writer, index int, user *User)
+
+// SearchOptions configure Search.
+type SearchOptions struct {
+	Query *Query
+	// Format defaults to DefaultFormat
+	Format ResultFormatter
+	// Fields lists extra User fields the formatter needs besides name,
+	// email and browsers, fields not used anywhere are not decoded
+	Fields []string
+	// Tolerant skips lines that can not be decoded and lists them in the
+	// report instead of failing on the first one
+	Tolerant bool
+}
+
+// DefaultFormat prints "[index] name <email>" with the email obfuscated.
+func DefaultFormat(out io.Writer, index int, user *User) {
+	fmt.Fprintf(out, "[%d] %s <%s>\n", index, user.Name, ObfuscateEmail(user.Email))
+}
+
+// ObfuscateEmail replaces @ with " [at] ".
+func ObfuscateEmail(email string) string {
+	return strings.Replace(email, "@", " [at] ", -1)
+}
+
+// вам надо написать более быструю оптимальную этой функции
+func FastSearch(out io.Writer) error {
+	_, err := SearchFile(out, filePath, SearchOptions{Query: defaultQuery})
+	return err
+}
+
+// Search prints users from r matching opts.Query followed by the number
+// of unique browsers matched by the query's browsers predicates.
+// Compressed input is detected and decompressed, see NewDatasetReader.
+func Search(out io.Writer, r io.Reader, opts SearchOptions) (*SearchReport, error) {
+	reader, release, err := NewDatasetReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var scratch []byte
+	return searchLines(out, func() ([]byte, error) {
+		return readLine(reader, &scratch)
+	}, opts)
+}
+
+// searchLines is Search over lines returned by next until io.EOF. The
+// line may be reused by next.
+func searchLines(out io.Writer, next func() ([]byte, error), opts SearchOptions) (*SearchReport, error) {
+	format := opts.formatter()
+	scanner := newUserScanner(opts)
+	report := &SearchReport{}
 	fmt.Fprintln(out, "found users:")
-	i := -1
-	for {
-		i++
-		line, _, err := reader.ReadLine()
+	for i := 0; ; i++ {
+		line, err := next()
 		if err == io.EOF {
 			break
 		}
-		err = user.UnmarshalJSON([]byte(line))
 		if err != nil {
-			panic(err)
+			return report, err
 		}
-		isAndroid := false
-		isMSIE := false
-		for _, browser := range user.Browsers {
-			if androidRe.MatchString(browser) {
-
-				isAndroid = true
-				if ok := seenBrowsers[browser]; !ok {
-					seenBrowsers[browser] = true
-				}
-			}
-			if msieRe.MatchString(browser) {
-				isMSIE = true
-				if ok := seenBrowsers[browser]; !ok {
-					seenBrowsers[browser] = true
-				}
+		report.Lines++
+		ok, err := scanner.scanLine(line)
+		if err != nil {
+			if lerr := report.skip(i, err, opts.Tolerant); lerr != nil {
+				return report, lerr
 			}
-
+			continue
 		}
-
-		if !(isAndroid && isMSIE) {
+		if !ok {
 			continue
 		}
-		fmt.Fprintf(out, "[%d] %s <%s>\n", i, user.Name, r.ReplaceAllString(user.Email, " [at] "))
-
+		report.Matched++
+		format(out, i, &scanner.user)
 	}
 	fmt.Fprintln(out)
-	fmt.Fprintln(out, "Total unique browsers", len(seenBrowsers))
+	fmt.Fprintln(out, "Total unique browsers", len(scanner.seen))
+	return report, nil
+}
+
+func (opts SearchOptions) formatter() ResultFormatter {
+	if opts.Format == nil {
+		return DefaultFormat
+	}
+	return opts.Format
+}
+
+// userScanner decodes lines and matches them against a query, keeping
+// the set of seen browsers. It is not safe for concurrent use.
+type userScanner struct {
+	query  *Query
+	fields fieldMask
+	leaves []bool
+	seen   map[string]bool
+	user   User
+}
+
+func newUserScanner(opts SearchOptions) *userScanner {
+	fields := opts.Query.fields | fieldName | fieldEmail | fieldBrowsers
+	for _, name := range opts.Fields {
+		fields |= queryFields[name]
+	}
+	return &userScanner{
+		query:  opts.Query,
+		fields: fields,
+		leaves: make([]bool, len(opts.Query.leaves)),
+		seen:   make(map[string]bool),
+	}
+}
+
+// scanLine decodes a line into s.user and reports whether it matches.
+func (s *userScanner) scanLine(line []byte) (bool, error) {
+	lexer := jlexer.Lexer{Data: line}
+	decodeUserFields(&lexer, &s.user, s.fields)
+	if err := lexer.Error(); err != nil {
+		return false, err
+	}
+	return s.query.match(&s.user, s.leaves, s.seen), nil
+}
+
+// decodeUserFields is the generated User decoder that skips fields not in
+// the mask instead of allocating strings for them.
+func decodeUserFields(in *jlexer.Lexer, out *User, fields fieldMask) {
+	*out = User{Browsers: out.Browsers[:0]}
+	in.Delim('{')
+	for !in.IsDelim('}') {
+		key := in.UnsafeFieldName(false)
+		in.WantColon()
+		if in.IsNull() {
+			in.Skip()
+			in.WantComma()
+			continue
+		}
+		field := queryFields[key]
+		if fields&field == 0 {
+			in.SkipRecursive()
+			in.WantComma()
+			continue
+		}
+		switch field {
+		case fieldBrowsers:
+			in.Delim('[')
+			for !in.IsDelim(']') {
+				out.Browsers = append(out.Browsers, in.String())
+				in.WantComma()
+			}
+			in.Delim(']')
+		case fieldCompany:
+			out.Company = in.String()
+		case fieldCountry:
+			out.Country = in.String()
+		case fieldEmail:
+			out.Email = in.String()
+		case fieldJob:
+			out.Job = in.String()
+		case fieldName:
+			out.Name = in.String()
+		case fieldPhone:
+			out.Phone = in.String()
+		}
+		in.WantComma()
+	}
+	in.Delim('}')
+	in.Consumed()
 }
 
 // suppress unused package warning
@@ -137,10 +435,18 @@ func easyjson9e1087fdDecodeCourseraGoHw3BenchUser(in *jlexer.Lexer, out *User) {
 				}
 				in.Delim(']')
 			}
+		case "company":
+			out.Company = string(in.String())
+		case "country":
+			out.Country = string(in.String())
 		case "email":
 			out.Email = string(in.String())
+		case "job":
+			out.Job = string(in.String())
 		case "name":
 			out.Name = string(in.String())
+		case "phone":
+			out.Phone = string(in.String())
 		default:
 			in.SkipRecursive()
 		}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/mailru/easyjson/jlexer"
+	"github.com/mailru/easyjson/jwriter"
+)
+
+// GenerateOptions configure GenerateDataset. Shares are per browsers
+// entry: with AndroidShare 0.1 every tenth entry is an Android one.
+type GenerateOptions struct {
+	Lines int
+	Seed  int64
+	// BrowsersPerUser defaults to 4 like data/users.txt
+	BrowsersPerUser int
+	AndroidShare    float64
+	MSIEShare       float64
+	// Pool overrides the built-in user agents, see BrowserPoolFrom
+	Pool *BrowserPool
+}
+
+// DefaultGenerateOptions match the shares of data/users.txt.
+func DefaultGenerateOptions(lines int, seed int64) GenerateOptions {
+	return GenerateOptions{
+		Lines:           lines,
+		Seed:            seed,
+		BrowsersPerUser: 4,
+		AndroidShare:    0.13,
+		MSIEShare:       0.07,
+	}
+}
+
+// BrowserPool holds user agents split the way the default query sees
+// them. An agent with both Android and MSIE is kept in Android only.
+type BrowserPool struct {
+	Android []string
+	MSIE    []string
+	Other   []string
+}
+
+func (p *BrowserPool) add(browser string) {
+	switch {
+	case strings.Contains(browser, "Android"):
+		p.Android = append(p.Android, browser)
+	case strings.Contains(browser, "MSIE"):
+		p.MSIE = append(p.MSIE, browser)
+	default:
+		p.Other = append(p.Other, browser)
+	}
+}
+
+var defaultPool = func() *BrowserPool {
+	p := &BrowserPool{}
+	for _, browser := range []string{
+		"Mozilla/5.0 (Linux; U; Android 2.0; en-us; Droid Build/ESD20) AppleWebKit/530.17 (KHTML, like Gecko) Version/4.0 Mobile Safari/530.17",
+		"Mozilla/5.0 (Linux; Android 6.0.1; SM-G900H Build/MMB29K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/52.0.2743.98 Mobile Safari/537.36",
+		"Opera/9.80 (Android; Opera Mini/7.5.33361/31.1543; U; en) Presto/2.8.119 Version/11.1010",
+		"Mozilla/5.0 (Android; Mobile; rv:35.0) Gecko/35.0 Firefox/35.0",
+		"Mozilla/5.0 (Linux; Android 5.1.1; Nexus 7 Build/LMY47V) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/43.0.2357.78 Safari/537.36 OPR/30.0.1856.93524",
+		"Mozilla/4.0 (compatible; MSIE 6.0; Windows CE; IEMobile 6.12; Microsoft ZuneHD 4.3)",
+		"Mozilla/5.0 (compatible; MSIE 10.0; Windows Phone 8.0; Trident/6.0; IEMobile/10.0; ARM; Touch; NOKIA; Lumia 920)",
+		"Mozilla/5.0 (compatible; MSIE 9.0; Windows Phone OS 7.5; Trident/5.0; IEMobile/9.0)",
+		"Mozilla/5.0 (compatible; MSIE 10.0; Windows NT 6.1; WOW64; Trident/6.0)",
+		"Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 5.1)",
+		"Mozilla/5.0 (iPad; U; CPU OS 3_2 like Mac OS X; en-us) AppleWebKit/531.21.10 (KHTML, like Gecko) Version/4.0.4 Mobile/7B334b Safari/531.21.10",
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/41.0.2227.0 Safari/537.36",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_7_3) AppleWebKit/534.55.3 (KHTML, like Gecko) Version/5.1.3 Safari/534.53.10",
+		"Mozilla/5.0 (Windows NT 6.2; ARM; Trident/7.0; Touch; rv:11.0; WPDesktop; NOKIA; Lumia 920) like Geckoo",
+		"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:35.0) Gecko/20100101 Firefox/35.0",
+		"Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/536.6 (KHTML, like Gecko) Chrome/20.0.1092.0 Safari/536.6",
+		"Mozilla/5.0 (Symbian/3; Series60/5.2 NokiaN8-00/014.002; Profile/MIDP-2.1 Configuration/CLDC-1.1; en-us) AppleWebKit/525 (KHTML, like Gecko) Version/3.0 BrowserNG/7.2.6.4 3gpp-gba",
+		"Opera/9.80 (X11; Linux i686) Presto/2.12.388 Version/12.16",
+		"BlackBerry8320/4.2.2 Profile/MIDP-2.0 Configuration/CLDC-1.1 VendorID/100",
+		"w3m/0.5.1",
+	} {
+		p.add(browser)
+	}
+	return p
+}()
+
+// BrowserPoolFrom collects the distinct browsers of a dataset.
+func BrowserPoolFrom(r io.Reader) (*BrowserPool, error) {
+	reader, release, err := NewDatasetReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	pool := &BrowserPool{}
+	seen := make(map[string]bool)
+	user := &User{}
+	var scratch []byte
+	for {
+		line, err := readLine(reader, &scratch)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		lexer := jlexer.Lexer{Data: line}
+		decodeUserFields(&lexer, user, fieldBrowsers)
+		if lexer.Error() != nil {
+			continue
+		}
+		for _, browser := range user.Browsers {
+			if !seen[browser] {
+				seen[browser] = true
+				pool.add(browser)
+			}
+		}
+	}
+	return pool, nil
+}
+
+var (
+	firstNames = []string{"Sharon", "Jonathan", "Maria", "Dennis", "Jessica", "Roy", "Lisa", "Harold", "Anna", "Victor", "Emily", "Peter", "Judith", "Walter", "Rose"}
+	lastNames  = []string{"Crawford", "Morris", "Hughes", "Ramirez", "Fox", "Kelley", "Olson", "Gordon", "Hart", "Mills", "Perry", "Lane", "Nichols", "Bryant"}
+	domains    = []string{"Muxo.edu", "Skiba.net", "Voonix.com", "Jabbertype.org", "Quimm.info", "Gabtune.gov", "Yodel.mil"}
+	companies  = []string{"Flashpoint", "Gigazoom", "Aibox", "Browseblab", "Camimbo", "Dablist", "Feedfire", "Jaxworks", "Linktype", "Oyoyo", "Quatz", "Realbridge", "Skinix", "Topicware", "Voolia", "Zoomzone"}
+	countries  = []string{"Peru", "Malta", "Namibia", "Chad", "Dominican Republic", "Saint Helena", "Mongolia", "Iceland", "Brazil", "Vietnam", "Kenya", "Norway", "Fiji", "Chile"}
+	jobs       = []string{"Programmer Analyst", "Nurse", "Geologist", "Financial Analyst", "Pilot", "Tax Accountant", "Web Designer", "Paralegal", "Civil Engineer", "Librarian"}
+)
+
+// GenerateDataset writes opts.Lines users in the format of
+// data/users.txt. The same options always produce the same output.
+func GenerateDataset(w io.Writer, opts GenerateOptions) error {
+	pool := opts.Pool
+	if pool == nil {
+		pool = defaultPool
+	}
+	if len(pool.Other) == 0 ||
+		opts.AndroidShare > 0 && len(pool.Android) == 0 ||
+		opts.MSIEShare > 0 && len(pool.MSIE) == 0 {
+		return fmt.Errorf("generate: browser pool has no agents for the requested shares")
+	}
+	if opts.AndroidShare < 0 || opts.MSIEShare < 0 || opts.AndroidShare+opts.MSIEShare > 1 {
+		return fmt.Errorf("generate: bad shares %v android, %v msie", opts.AndroidShare, opts.MSIEShare)
+	}
+	perUser := opts.BrowsersPerUser
+	if perUser < 1 {
+		perUser = 4
+	}
+
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	pick := func(list []string) string {
+		return list[rnd.Intn(len(list))]
+	}
+	bw := bufio.NewWriterSize(w, 64*1024)
+	user := User{Browsers: make([]string, perUser)}
+	jw := &jwriter.Writer{}
+	for i := 0; i < opts.Lines; i++ {
+		for j := range user.Browsers {
+			switch x := rnd.Float64(); {
+			case x < opts.AndroidShare:
+				user.Browsers[j] = pick(pool.Android)
+			case x < opts.AndroidShare+opts.MSIEShare:
+				user.Browsers[j] = pick(pool.MSIE)
+			default:
+				user.Browsers[j] = pick(pool.Other)
+			}
+		}
+		user.Name = pick(firstNames) + " " + pick(lastNames)
+		user.Email = pick(firstNames) + pick(lastNames) + "@" + pick(domains)
+		user.Company = pick(companies)
+		user.Country = pick(countries)
+		user.Job = pick(jobs)
+		user.Phone = strconv.Itoa(100+rnd.Intn(900)) + "-" + strconv.Itoa(10+rnd.Intn(90)) + "-" + strconv.Itoa(10+rnd.Intn(90))
+
+		// как в data/users.txt, без перевода строки в конце: SlowSearch
+		// считает пустую последнюю строку ошибкой
+		if i > 0 {
+			jw.RawByte('\n')
+		}
+		writeRecord(jw, &user)
+		if _, err := jw.DumpTo(bw); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// writeRecord writes user as a dataset line with all fields. User's own
+// MarshalJSON leaves out the ones it does not show, phone among them.
+func writeRecord(jw *jwriter.Writer, user *User) {
+	jw.RawString(`{"browsers":[`)
+	for i, b := range user.Browsers {
+		if i > 0 {
+			jw.RawByte(',')
+		}
+		jw.String(b)
+	}
+	jw.RawByte(']')
+	fields := [...]struct{ key, val string }{
+		{"company", user.Company},
+		{"country", user.Country},
+		{"email", user.Email},
+		{"job", user.Job},
+		{"name", user.Name},
+		{"phone", user.Phone},
+	}
+	for _, f := range fields {
+		jw.RawString(`,"` + f.key + `":`)
+		jw.String(f.val)
+	}
+	jw.RawByte('}')
+}
+
+// ParseCount parses a line count with an optional k or M suffix.
+func ParseCount(s string) (int, error) {
+	mult := 1
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult, s = 1000, s[:len(s)-1]
+	case strings.HasSuffix(s, "M"):
+		mult, s = 1000000, s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad count %q", s)
+	}
+	return int(n * float64(mult)), nil
+}
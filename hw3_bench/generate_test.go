@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateDataset(t *testing.T) {
+	gen := func(opts GenerateOptions) string {
+		out := new(bytes.Buffer)
+		if err := GenerateDataset(out, opts); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	opts := DefaultGenerateOptions(2000, 42)
+	data := gen(opts)
+	if data != gen(opts) {
+		t.Error("same seed produced different datasets")
+	}
+	if data == gen(DefaultGenerateOptions(2000, 43)) {
+		t.Error("different seeds produced the same dataset")
+	}
+	if lines := strings.Count(data, "\n") + 1; lines != 2000 || strings.HasSuffix(data, "\n") {
+		t.Errorf("expected 2000 lines without trailing newline, got %d", lines)
+	}
+
+	stats, _, err := CollectBrowserStats(strings.NewReader(data), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	android, msie := 0, 0
+	for browser := range stats.parsed {
+		switch {
+		case strings.Contains(browser, "Android"):
+			android += strings.Count(data, browser)
+		case strings.Contains(browser, "MSIE"):
+			msie += strings.Count(data, browser)
+		}
+	}
+	if share := float64(android) / float64(stats.Total); share < 0.11 || share > 0.15 {
+		t.Errorf("android share %.3f, expected about 0.13", share)
+	}
+	if share := float64(msie) / float64(stats.Total); share < 0.05 || share > 0.09 {
+		t.Errorf("msie share %.3f, expected about 0.07", share)
+	}
+
+	// медленный и быстрый поиск должны совпадать и на сгенерированных данных
+	withDataset(t, data)
+	slowOut, fastOut := new(bytes.Buffer), new(bytes.Buffer)
+	if err := SlowSearch(slowOut); err != nil {
+		t.Fatal(err)
+	}
+	if err := FastSearch(fastOut); err != nil {
+		t.Fatal(err)
+	}
+	if slowOut.String() != fastOut.String() {
+		t.Error("slow and fast results differ on generated data")
+	}
+
+	// в файле все поля, а json.Marshal по-прежнему не выдает телефон
+	user := User{}
+	if err := user.UnmarshalJSON([]byte(data[:strings.Index(data, "\n")])); err != nil {
+		t.Fatal(err)
+	}
+	if user.Phone == "" || user.Company == "" || user.Country == "" || user.Job == "" {
+		t.Errorf("generated line lacks fields: %+v", user)
+	}
+	encoded, err := json.Marshal(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{user.Phone, user.Company, user.Country, user.Job} {
+		if bytes.Contains(encoded, []byte(field)) {
+			t.Errorf("json.Marshal leaks %q: %s", field, encoded)
+		}
+	}
+
+	opts.AndroidShare, opts.MSIEShare = 0.7, 0.5
+	if err := GenerateDataset(new(bytes.Buffer), opts); err == nil {
+		t.Error("expected error for shares above 1")
+	}
+}
+
+func TestBrowserPoolFrom(t *testing.T) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	pool, err := BrowserPoolFrom(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.Android) == 0 || len(pool.MSIE) == 0 || len(pool.Other) == 0 {
+		t.Fatalf("empty pool part: %d android, %d msie, %d other", len(pool.Android), len(pool.MSIE), len(pool.Other))
+	}
+	opts := DefaultGenerateOptions(10, 1)
+	opts.Pool = pool
+	if err := GenerateDataset(new(bytes.Buffer), opts); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseCount(t *testing.T) {
+	cases := map[string]int{"10": 10, "5k": 5000, "2M": 2000000, "1.5M": 1500000}
+	for s, expected := range cases {
+		if n, err := ParseCount(s); err != nil || n != expected {
+			t.Errorf("ParseCount(%q) = %d, %v, expected %d", s, n, err, expected)
+		}
+	}
+	for _, s := range []string{"", "k", "-1", "ten"} {
+		if _, err := ParseCount(s); err == nil {
+			t.Errorf("ParseCount(%q): expected error", s)
+		}
+	}
+}
+
+func TestWriteBenchTable(t *testing.T) {
+	results := []BenchResult{
+		{Variant: "Slow", Lines: 1000, N: 10, T: 300 * time.Millisecond, MemAllocs: 1720000, MemBytes: 176000000},
+		{Variant: "Fast", Lines: 1000, N: 100, T: 150 * time.Millisecond, MemAllocs: 634000, MemBytes: 53900000},
+	}
+	out := new(bytes.Buffer)
+	WriteBenchTable(out, results)
+	expected := "  name             time/op  alloc/op  allocs/op  vs base\n" +
+		"  Slow/lines=1000   30.0ms    17.6MB       172k        ~\n" +
+		"  Fast/lines=1000   1.50ms     539kB      6.34k   -95.0%\n"
+	if out.String() != expected {
+		t.Errorf("unexpected table\nGot:\n%s\nExpected:\n%s", out, expected)
+	}
+}
+
+func TestRunBenchmarks(t *testing.T) {
+	out := new(bytes.Buffer)
+	results, err := RunBenchmarks(out, BenchOptions{
+		Sizes:     []int{200},
+		Variants:  []string{"Slow", "Fast"},
+		Dir:       t.TempDir(),
+		Generate:  DefaultGenerateOptions(0, 1),
+		BenchTime: "3x",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Variant != "Slow" || results[1].Variant != "Fast" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	for _, r := range results {
+		if r.N != 3 || r.Lines != 200 || r.T <= 0 || r.MemAllocs == 0 {
+			t.Errorf("bad measurement %+v", r)
+		}
+	}
+	if !strings.Contains(out.String(), "Fast/lines=200") {
+		t.Errorf("no table in output:\n%s", out)
+	}
+
+	for _, bench := range []string{"0x", "tenx", "-1s", "fast"} {
+		if _, err := RunBenchmarks(out, BenchOptions{Sizes: []int{10}, BenchTime: bench}); err == nil {
+			t.Errorf("benchtime %q: expected error", bench)
+		}
+	}
+}
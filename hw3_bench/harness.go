@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// searchVariant is a search implementation run by the harness over the
+// dataset at path.
+type searchVariant struct {
+	name string
+	// prepare runs once per dataset outside of the measurement
+	prepare func(path string) (run func() error, err error)
+}
+
+var searchVariants = []searchVariant{
+	{"Slow", func(path string) (func() error, error) {
+		return func() error {
+			_, err := SlowSearchFile(ioutil.Discard, path, false)
+			return err
+		}, nil
+	}},
+	{"Fast", func(path string) (func() error, error) {
+		return func() error {
+			_, err := SearchFile(ioutil.Discard, path, SearchOptions{Query: defaultQuery})
+			return err
+		}, nil
+	}},
+	{"Parallel", func(path string) (func() error, error) {
+		return func() error {
+			_, err := ParallelSearch(ioutil.Discard, path, SearchOptions{Query: defaultQuery}, 0)
+			return err
+		}, nil
+	}},
+	{"Scan", func(path string) (func() error, error) {
+		return func() error {
+			_, err := ScanSearchFile(ioutil.Discard, path, SearchOptions{Query: defaultQuery})
+			return err
+		}, nil
+	}},
+	{"Mmap", func(path string) (func() error, error) {
+		return func() error {
+			_, err := MmapSearch(ioutil.Discard, path, SearchOptions{Query: defaultQuery})
+			return err
+		}, nil
+	}},
+	{"Index", func(path string) (func() error, error) {
+		idx, err := BuildIndex(path)
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			_, err := IndexSearch(ioutil.Discard, idx, SearchOptions{Query: defaultQuery})
+			return err
+		}, nil
+	}},
+}
+
+// BenchOptions configure RunBenchmarks.
+type BenchOptions struct {
+	// Sizes are dataset sizes in lines
+	Sizes []int
+	// Variants names searches to run, all of them if empty
+	Variants []string
+	// Dir keeps generated datasets, a temporary directory if empty
+	Dir      string
+	Generate GenerateOptions
+	// BenchTime is how long to run each variant like go test -benchtime:
+	// a duration such as "2s" or a fixed number of runs such as "10x",
+	// one second if empty
+	BenchTime string
+}
+
+// BenchResult is a single measurement, the fields are named as in
+// testing.BenchmarkResult.
+type BenchResult struct {
+	Variant string
+	Lines   int
+	// N runs took T and allocated MemBytes in MemAllocs allocations
+	N         int
+	T         time.Duration
+	MemAllocs uint64
+	MemBytes  uint64
+}
+
+func (r *BenchResult) NsPerOp() int64 {
+	if r.N <= 0 {
+		return 0
+	}
+	return r.T.Nanoseconds() / int64(r.N)
+}
+
+func (r *BenchResult) AllocsPerOp() int64 {
+	if r.N <= 0 {
+		return 0
+	}
+	return int64(r.MemAllocs) / int64(r.N)
+}
+
+func (r *BenchResult) AllocedBytesPerOp() int64 {
+	if r.N <= 0 {
+		return 0
+	}
+	return int64(r.MemBytes) / int64(r.N)
+}
+
+// RunBenchmarks generates a dataset of every size and measures every
+// variant over it, then prints a benchstat-like table with the speedup
+// relative to the first variant.
+func RunBenchmarks(out io.Writer, opts BenchOptions) ([]BenchResult, error) {
+	variants, err := selectVariants(opts.Variants)
+	if err != nil {
+		return nil, err
+	}
+	benchTime, runs, err := parseBenchTime(opts.BenchTime)
+	if err != nil {
+		return nil, err
+	}
+	dir := opts.Dir
+	if dir == "" {
+		if dir, err = ioutil.TempDir("", "hw3_bench"); err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	results := []BenchResult{}
+	for _, size := range opts.Sizes {
+		path := filepath.Join(dir, "users_"+strconv.Itoa(size)+".txt")
+		if err := generateFile(path, size, opts.Generate); err != nil {
+			return nil, err
+		}
+		for _, v := range variants {
+			run, err := v.prepare(path)
+			if err != nil {
+				return nil, fmt.Errorf("%s/%d: %s", v.name, size, err)
+			}
+			res, err := measure(run, benchTime, runs)
+			if err != nil {
+				return nil, fmt.Errorf("%s/%d: %s", v.name, size, err)
+			}
+			res.Variant, res.Lines = v.name, size
+			results = append(results, res)
+		}
+	}
+	WriteBenchTable(out, results)
+	return results, nil
+}
+
+// parseBenchTime parses -benchtime: "10x" is a number of runs, anything
+// else a duration.
+func parseBenchTime(s string) (time.Duration, int, error) {
+	if s == "" {
+		return time.Second, 0, nil
+	}
+	if strings.HasSuffix(s, "x") {
+		runs, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || runs <= 0 {
+			return 0, 0, fmt.Errorf("bad benchtime %q", s)
+		}
+		return 0, runs, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, 0, fmt.Errorf("bad benchtime %q", s)
+	}
+	return d, 0, nil
+}
+
+// measure times run like testing.Benchmark does: exactly runs times if
+// runs is set, otherwise with a growing number of runs until they take
+// benchTime.
+func measure(run func() error, benchTime time.Duration, runs int) (BenchResult, error) {
+	// прогрев, заодно проверяем, что вариант вообще работает
+	if err := run(); err != nil {
+		return BenchResult{}, err
+	}
+	n := runs
+	if n == 0 {
+		n = 1
+	}
+	for {
+		res, err := measureN(run, n)
+		if err != nil || runs > 0 || res.T >= benchTime || n >= 1e9 {
+			return res, err
+		}
+		// как в testing: с запасом 20%, но не больше чем в 100 раз за шаг
+		next := n * 100
+		if perRun := res.T.Nanoseconds() / int64(n); perRun > 0 {
+			if predicted := int(benchTime.Nanoseconds() * 6 / 5 / perRun); predicted < next {
+				next = predicted
+			}
+		}
+		if next <= n {
+			next = n + 1
+		}
+		n = next
+	}
+}
+
+func measureN(run func() error, n int) (BenchResult, error) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		if err := run(); err != nil {
+			return BenchResult{}, err
+		}
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	return BenchResult{
+		N:         n,
+		T:         elapsed,
+		MemAllocs: after.Mallocs - before.Mallocs,
+		MemBytes:  after.TotalAlloc - before.TotalAlloc,
+	}, nil
+}
+
+func selectVariants(names []string) ([]searchVariant, error) {
+	if len(names) == 0 {
+		return searchVariants, nil
+	}
+	res := []searchVariant{}
+	for _, name := range names {
+		found := false
+		for _, v := range searchVariants {
+			if v.name == name {
+				res = append(res, v)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown search variant %q", name)
+		}
+	}
+	return res, nil
+}
+
+func generateFile(path string, lines int, opts GenerateOptions) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	opts.Lines = lines
+	err = GenerateDataset(file, opts)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// WriteBenchTable prints results in the layout of benchstat. The delta
+// column compares to the first variant measured at the same size.
+func WriteBenchTable(out io.Writer, results []BenchResult) {
+	names := make([]string, len(results))
+	width := len("name")
+	for i, r := range results {
+		names[i] = fmt.Sprintf("%s/lines=%d", r.Variant, r.Lines)
+		if len(names[i]) > width {
+			width = len(names[i])
+		}
+	}
+	// tabwriter выравнивает все колонки вправо, имена дополняем сами
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "%-*s\ttime/op\talloc/op\tallocs/op\tvs base\t\n", width, "name")
+	base := map[int]BenchResult{}
+	for i, r := range results {
+		delta := "~"
+		if b, ok := base[r.Lines]; ok {
+			delta = fmt.Sprintf("%+.1f%%", (float64(r.NsPerOp())/float64(b.NsPerOp())-1)*100)
+		} else {
+			base[r.Lines] = r
+		}
+		fmt.Fprintf(tw, "%-*s\t%s\t%s\t%s\t%s\t\n", width, names[i],
+			humanUnits(float64(r.NsPerOp()), "s"),
+			humanUnits(float64(r.AllocedBytesPerOp()), "B"),
+			humanUnits(float64(r.AllocsPerOp()), ""),
+			delta)
+	}
+	tw.Flush()
+}
+
+// humanUnits formats v with three significant digits and an SI prefix,
+// like benchstat does: 12.3ms, 4.56MB, 789k. Time is in nanoseconds.
+func humanUnits(v float64, unit string) string {
+	if v == 0 {
+		return "0" + unit
+	}
+	prefixes := []string{"", "k", "M", "G", "T"}
+	if unit == "s" {
+		prefixes = []string{"n", "µ", "m", ""}
+	}
+	i := 0
+	for v >= 1000 && i < len(prefixes)-1 {
+		v /= 1000
+		i++
+	}
+	format := "%.0f%s%s"
+	switch {
+	case v < 10:
+		format = "%.2f%s%s"
+	case v < 100:
+		format = "%.1f%s%s"
+	}
+	return fmt.Sprintf(format, v, prefixes[i], unit)
+}
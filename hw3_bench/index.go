@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/mailru/easyjson/jlexer"
+)
+
+// Index is an inverted index over a plain dataset file: for browsers and
+// optionally other fields it maps every distinct value to the sorted
+// numbers of lines containing it. Lines that can not be decoded are listed
+// in Bad, searches read them to fail or skip them like SearchFile does.
+type Index struct {
+	// Fields are indexed in addition to browsers
+	Fields []string
+	// Size is the number of bytes of the dataset covered by the index
+	Size int64
+	// Checksum is the CRC-32 of those bytes, it tells an appended dataset
+	// from a rewritten one
+	Checksum uint32
+	// Complete is false if the last indexed line had no trailing newline
+	// and may still grow
+	Complete bool
+	// Offsets holds the start of every line
+	Offsets  []int64
+	Postings map[string]map[string][]int
+	Bad      []int
+
+	dataPath string
+	mask     fieldMask
+}
+
+// BuildIndex indexes the whole dataset at dataPath.
+func BuildIndex(dataPath string, fields ...string) (*Index, error) {
+	idx := &Index{
+		Fields:   fields,
+		Complete: true,
+		Postings: make(map[string]map[string][]int),
+		dataPath: dataPath,
+	}
+	if err := idx.init(); err != nil {
+		return nil, err
+	}
+	if _, err := idx.Update(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// OpenIndex loads the index saved at indexPath, brings it up to date with
+// lines appended to dataPath since and saves it back if anything changed.
+// A missing index, one built for other fields or a dataset that shrank
+// or was rewritten are rebuilt.
+func OpenIndex(indexPath, dataPath string, fields ...string) (*Index, error) {
+	idx, err := LoadIndex(indexPath, dataPath)
+	if err == nil && !sameFields(idx.Fields, fields) {
+		err = fmt.Errorf("index fields changed")
+	}
+	var size int64
+	if err == nil {
+		size = idx.Size
+		_, err = idx.Update()
+	}
+	if err != nil {
+		if idx, err = BuildIndex(dataPath, fields...); err != nil {
+			return nil, err
+		}
+	} else if idx.Size == size {
+		return idx, nil
+	}
+	return idx, idx.Save(indexPath)
+}
+
+// LoadIndex reads an index saved with Save without updating it.
+func LoadIndex(indexPath, dataPath string) (*Index, error) {
+	file, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	idx := &Index{}
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(idx); err != nil {
+		return nil, err
+	}
+	idx.dataPath = dataPath
+	if idx.Postings == nil {
+		idx.Postings = make(map[string]map[string][]int)
+	}
+	return idx, idx.init()
+}
+
+// Save writes the index to path atomically.
+func (idx *Index) Save(path string) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	err = gob.NewEncoder(w).Encode(idx)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (idx *Index) init() error {
+	idx.mask = fieldBrowsers
+	for _, name := range idx.Fields {
+		field, ok := queryFields[name]
+		if !ok {
+			return fmt.Errorf("index: unknown field %q", name)
+		}
+		idx.mask |= field
+	}
+	return nil
+}
+
+func sameFields(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Lines returns the number of indexed lines.
+func (idx *Index) Lines() int {
+	return len(idx.Offsets)
+}
+
+// Update indexes lines appended to the dataset since the last update and
+// returns how many lines were added. A dataset that got shorter or whose
+// indexed part no longer matches Checksum is an error, the whole indexed
+// part is read to check it.
+func (idx *Index) Update() (int, error) {
+	file, err := os.Open(idx.dataPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() < idx.Size {
+		return 0, fmt.Errorf("index: dataset shrank from %d to %d bytes", idx.Size, info.Size())
+	}
+	sum, err := checksum(0, file, 0, idx.Size)
+	if err != nil {
+		return 0, err
+	}
+	if sum != idx.Checksum {
+		return 0, fmt.Errorf("index: dataset changed in the first %d bytes", idx.Size)
+	}
+	if info.Size() == idx.Size {
+		return 0, nil
+	}
+	if sum, err = checksum(sum, file, idx.Size, info.Size()); err != nil {
+		return 0, err
+	}
+
+	start := idx.Size
+	before := len(idx.Offsets)
+	if !idx.Complete && len(idx.Offsets) > 0 {
+		// последняя строка могла дописаться, индексируем ее заново
+		start = idx.Offsets[len(idx.Offsets)-1]
+		idx.dropLastLine()
+		before--
+	}
+
+	reader := bufio.NewReaderSize(io.NewSectionReader(file, start, info.Size()-start), 64*1024)
+	user := &User{}
+	offset := start
+	complete := true
+	var scratch []byte
+	for {
+		line, n, ended, err := readIndexLine(reader, &scratch)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		lineNum := len(idx.Offsets)
+		idx.Offsets = append(idx.Offsets, offset)
+		offset += int64(n)
+		complete = ended
+
+		// декодируем все поля: строка, на которой споткнется любой поиск,
+		// должна попасть в Bad
+		lexer := jlexer.Lexer{Data: line}
+		decodeUserFields(&lexer, user, allFields)
+		if lexer.Error() != nil {
+			idx.Bad = append(idx.Bad, lineNum)
+			continue
+		}
+		for _, browser := range uniqueStrings(user.Browsers) {
+			idx.addPosting("browsers", browser, lineNum)
+		}
+		for _, name := range idx.Fields {
+			idx.addPosting(name, queryFields[name].get(user), lineNum)
+		}
+	}
+	idx.Size = info.Size()
+	idx.Checksum = sum
+	idx.Complete = complete
+	return len(idx.Offsets) - before, nil
+}
+
+// checksum continues the CRC-32 sum over bytes [from, to) of file.
+func checksum(sum uint32, file io.ReaderAt, from, to int64) (uint32, error) {
+	n, err := io.Copy(crc32Writer{&sum}, io.NewSectionReader(file, from, to-from))
+	if err == nil && n != to-from {
+		err = io.ErrUnexpectedEOF
+	}
+	return sum, err
+}
+
+// crc32Writer updates the IEEE CRC-32 in sum with everything written.
+type crc32Writer struct {
+	sum *uint32
+}
+
+func (w crc32Writer) Write(p []byte) (int, error) {
+	*w.sum = crc32.Update(*w.sum, crc32.IEEETable, p)
+	return len(p), nil
+}
+
+// allFields has every field a query may use.
+var allFields = func() fieldMask {
+	mask := fieldBrowsers
+	for _, field := range queryFields {
+		mask |= field
+	}
+	return mask
+}()
+
+// readIndexLine is readLine that also returns how many bytes the line took
+// in the file with its line end and whether it ended with a newline.
+func readIndexLine(r *bufio.Reader, scratch *[]byte) ([]byte, int, bool, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		buf := append((*scratch)[:0], line...)
+		for err == bufio.ErrBufferFull {
+			line, err = r.ReadSlice('\n')
+			buf = append(buf, line...)
+		}
+		*scratch = buf
+		line = buf
+	}
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	n := len(line)
+	ended := line[n-1] == '\n'
+	if ended {
+		line = trimLineEnd(line)
+	}
+	return line, n, ended, nil
+}
+
+// trimLineEnd cuts "\n" or "\r\n" like bufio.Reader.ReadLine.
+func trimLineEnd(line []byte) []byte {
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line
+}
+
+func (idx *Index) addPosting(field, value string, line int) {
+	values := idx.Postings[field]
+	if values == nil {
+		values = make(map[string][]int)
+		idx.Postings[field] = values
+	}
+	values[value] = append(values[value], line)
+}
+
+// dropLastLine removes the last line from offsets, postings and Bad.
+func (idx *Index) dropLastLine() {
+	last := len(idx.Offsets) - 1
+	idx.Offsets = idx.Offsets[:last]
+	if n := len(idx.Bad); n > 0 && idx.Bad[n-1] == last {
+		idx.Bad = idx.Bad[:n-1]
+	}
+	for _, values := range idx.Postings {
+		for value, lines := range values {
+			if n := len(lines); n > 0 && lines[n-1] == last {
+				if n == 1 {
+					delete(values, value)
+				} else {
+					values[value] = lines[:n-1]
+				}
+			}
+		}
+	}
+}
+
+func uniqueStrings(list []string) []string {
+	res := list[:0:0]
+	for i, s := range list {
+		dup := false
+		for _, prev := range list[:i] {
+			if prev == s {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// Candidates returns sorted numbers of lines that may match q. Predicates
+// over browsers and indexed fields are answered from postings, the rest
+// (and anything under NOT) can not narrow the result.
+func (idx *Index) Candidates(q *Query) []int {
+	lines, exact := idx.candidates(q, q.root)
+	if !exact && lines == nil {
+		return idx.allLines()
+	}
+	return lines
+}
+
+// candidates returns the lines for node; exact false with nil lines means
+// every line is a candidate.
+func (idx *Index) candidates(q *Query, node queryNode) ([]int, bool) {
+	switch n := node.(type) {
+	case leafNode:
+		p := q.leaves[n]
+		if idx.mask&p.field == 0 {
+			return nil, false
+		}
+		lists := [][]int{}
+		for value, lines := range idx.Postings[p.field.String()] {
+			if p.matchString(value) {
+				lists = append(lists, lines)
+			}
+		}
+		return unionLines(lists), true
+	case andNode:
+		var res []int
+		found := false
+		for _, c := range n {
+			lines, exact := idx.candidates(q, c)
+			if !exact && lines == nil {
+				continue
+			}
+			if !found {
+				res, found = lines, true
+				continue
+			}
+			res = intersectLines(res, lines)
+		}
+		if !found {
+			return nil, false
+		}
+		return res, false
+	case orNode:
+		lists := [][]int{}
+		for _, c := range n {
+			lines, exact := idx.candidates(q, c)
+			if !exact && lines == nil {
+				return nil, false
+			}
+			lists = append(lists, lines)
+		}
+		return unionLines(lists), false
+	}
+	return nil, false
+}
+
+func (idx *Index) allLines() []int {
+	res := make([]int, len(idx.Offsets))
+	for i := range res {
+		res[i] = i
+	}
+	return res
+}
+
+func intersectLines(a, b []int) []int {
+	res := []int{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
+
+func unionLines(lists [][]int) []int {
+	switch len(lists) {
+	case 0:
+		return []int{}
+	case 1:
+		return lists[0]
+	}
+	total := 0
+	for _, l := range lists {
+		total += len(l)
+	}
+	res := make([]int, 0, total)
+	for _, l := range lists {
+		res = append(res, l...)
+	}
+	sort.Ints(res)
+	uniq := res[:0]
+	for i, line := range res {
+		if i == 0 || line != res[i-1] {
+			uniq = append(uniq, line)
+		}
+	}
+	return uniq
+}
+
+// IndexSearch answers a query with the index: candidate lines are read
+// from the dataset and checked against the query, unique browsers are
+// counted over the distinct indexed browsers. The output matches
+// SearchFile on the same dataset.
+func IndexSearch(out io.Writer, idx *Index, opts SearchOptions) (*SearchReport, error) {
+	file, err := os.Open(idx.dataPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	format := opts.formatter()
+	scanner := newUserScanner(opts)
+	report := &SearchReport{Lines: len(idx.Offsets)}
+	var buf []byte
+	fmt.Fprintln(out, "found users:")
+	lines := idx.Candidates(opts.Query)
+	if len(idx.Bad) > 0 {
+		lines = unionLines([][]int{lines, idx.Bad})
+	}
+	for _, i := range lines {
+		end := idx.Size
+		if i+1 < len(idx.Offsets) {
+			end = idx.Offsets[i+1]
+		}
+		if n := int(end - idx.Offsets[i]); cap(buf) < n {
+			buf = make([]byte, n)
+		} else {
+			buf = buf[:n]
+		}
+		if _, err := file.ReadAt(buf, idx.Offsets[i]); err != nil {
+			return report, err
+		}
+		if i+1 < len(idx.Offsets) || idx.Complete {
+			buf = trimLineEnd(buf)
+		}
+		ok, err := scanner.scanLine(buf)
+		if err != nil {
+			if lerr := report.skip(i, err, opts.Tolerant); lerr != nil {
+				return report, lerr
+			}
+			continue
+		}
+		if ok {
+			report.Matched++
+			format(out, i, &scanner.user)
+		}
+	}
+
+	seenBrowsers := 0
+	for browser := range idx.Postings["browsers"] {
+		for _, p := range opts.Query.leaves {
+			if p.field == fieldBrowsers && p.matchString(browser) {
+				seenBrowsers++
+				break
+			}
+		}
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Total unique browsers", seenBrowsers)
+	return report, nil
+}
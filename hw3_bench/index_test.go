@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var indexQueries = []string{
+	"browsers~Android AND browsers~MSIE",
+	"browsers~Android AND browsers~MSIE AND country=Peru",
+	"browsers~/MSIE [5-6]/ OR country~Uni",
+	"NOT browsers~Android AND name~/^J/",
+	"browsers~Opera",
+}
+
+func checkIndexSearch(t *testing.T, idx *Index, dataPath string) {
+	for _, src := range indexQueries {
+		opts := SearchOptions{Query: MustCompileQuery(src)}
+		expected := new(bytes.Buffer)
+		if _, err := SearchFile(expected, dataPath, opts); err != nil {
+			t.Fatal(err)
+		}
+		out := new(bytes.Buffer)
+		if _, err := IndexSearch(out, idx, opts); err != nil {
+			t.Errorf("[%s] unexpected error: %s", src, err)
+		}
+		if out.String() != expected.String() {
+			t.Errorf("[%s] results not match\nGot:\n%v\nExpected:\n%v", src, out.String(), expected.String())
+		}
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	idx, err := BuildIndex(filePath, "country")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Lines() != 1000 {
+		t.Errorf("expected 1000 lines, got %d", idx.Lines())
+	}
+	checkIndexSearch(t, idx, filePath)
+
+	cands := idx.Candidates(defaultQuery)
+	if len(cands) == 0 || len(cands) > 100 {
+		t.Errorf("index does not narrow the search: %d candidates", len(cands))
+	}
+}
+
+func TestIndexIncremental(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	dataPath := filepath.Join(dir, "users.txt")
+	indexPath := filepath.Join(dir, "users.idx")
+
+	// первая часть обрывается посреди строки, вторая ее дописывает
+	parts := [][]byte{data[:len(data)/2], data[len(data)/2 : len(data)*3/4], data[len(data)*3/4:]}
+	if err := ioutil.WriteFile(dataPath, parts[0], 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := OpenIndex(indexPath, dataPath, "country")
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstLines := idx.Lines()
+
+	for _, part := range parts[1:] {
+		f, err := os.OpenFile(dataPath, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(part)
+		f.Close()
+		if idx, err = OpenIndex(indexPath, dataPath, "country"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if idx.Lines() != 1000 || firstLines >= 1000 {
+		t.Errorf("wrong line count: %d, first %d", idx.Lines(), firstLines)
+	}
+	checkIndexSearch(t, idx, dataPath)
+
+	added, err := idx.Update()
+	if err != nil || added != 0 {
+		t.Errorf("expected no changes, got %d, %v", added, err)
+	}
+
+	// усеченный файл переиндексируется целиком
+	if err := ioutil.WriteFile(dataPath, parts[0], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.Update(); err == nil {
+		t.Errorf("expected error for shrunk dataset")
+	}
+	if idx, err = OpenIndex(indexPath, dataPath, "country"); err != nil {
+		t.Fatal(err)
+	}
+	if idx.Lines() != firstLines {
+		t.Errorf("expected rebuilt index with %d lines, got %d", firstLines, idx.Lines())
+	}
+}
+
+func TestIndexRewritten(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	dataPath := filepath.Join(dir, "users.txt")
+	indexPath := filepath.Join(dir, "users.idx")
+
+	// те же строки в обратном порядке: размер прежний, содержимое другое
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	reversed := make([][]byte, len(lines))
+	for i, line := range lines {
+		reversed[len(lines)-1-i] = line
+	}
+	half := append(bytes.Join(lines[:len(lines)/2], []byte("\n")), '\n')
+	versions := map[string][]byte{
+		"same size": append(bytes.Join(reversed[len(lines)/2:], []byte("\n")), '\n'),
+		"larger":    append(bytes.Join(reversed, []byte("\n")), '\n'),
+	}
+	for name, rewritten := range versions {
+		if len(rewritten) < len(half) {
+			t.Fatalf("[%s] rewritten dataset is shorter than the indexed one", name)
+		}
+		if err := ioutil.WriteFile(dataPath, half, 0644); err != nil {
+			t.Fatal(err)
+		}
+		idx, err := BuildIndex(dataPath, "country")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := idx.Save(indexPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(dataPath, rewritten, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := idx.Update(); err == nil {
+			t.Errorf("[%s] expected error for rewritten dataset", name)
+		}
+		if idx, err = OpenIndex(indexPath, dataPath, "country"); err != nil {
+			t.Fatal(err)
+		}
+		checkIndexSearch(t, idx, dataPath)
+	}
+}
+
+func TestIndexCRLF(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataPath := filepath.Join(t.TempDir(), "users.txt")
+	crlf := bytes.Replace(data, []byte("\n"), []byte("\r\n"), -1)
+	if err := ioutil.WriteFile(dataPath, crlf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := BuildIndex(dataPath, "country")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Lines() != 1000 || len(idx.Bad) != 0 {
+		t.Errorf("expected 1000 good lines, got %d, bad %v", idx.Lines(), idx.Bad)
+	}
+	checkIndexSearch(t, idx, dataPath)
+}
+
+func TestIndexBadLines(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	lines[3] = []byte(`{"browsers":["MSIE 6.0 Android"],"name":`)
+	lines[10] = []byte(`not json`)
+	dataPath := filepath.Join(t.TempDir(), "users.txt")
+	if err := ioutil.WriteFile(dataPath, bytes.Join(lines, []byte("\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := BuildIndex(dataPath, "country")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Bad) != 2 || idx.Bad[0] != 3 || idx.Bad[1] != 10 {
+		t.Errorf("wrong bad lines %v", idx.Bad)
+	}
+
+	for _, src := range indexQueries {
+		for _, tolerant := range []bool{false, true} {
+			opts := SearchOptions{Query: MustCompileQuery(src), Tolerant: tolerant}
+			expected, out := new(bytes.Buffer), new(bytes.Buffer)
+			want, wantErr := SearchFile(expected, dataPath, opts)
+			got, err := IndexSearch(out, idx, opts)
+			if fmt.Sprint(err) != fmt.Sprint(wantErr) {
+				t.Errorf("[%s %v] error %v, SearchFile gives %v", src, tolerant, err, wantErr)
+			}
+			if tolerant && fmt.Sprint(got.Skipped) != fmt.Sprint(want.Skipped) {
+				t.Errorf("[%s] skipped %v, SearchFile skips %v", src, got.Skipped, want.Skipped)
+			}
+			if out.String() != expected.String() {
+				t.Errorf("[%s %v] results not match\nGot:\n%v\nExpected:\n%v", src, tolerant, out.String(), expected.String())
+			}
+		}
+	}
+}
+
+func TestOpenIndexSavesChanges(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	dataPath := filepath.Join(dir, "users.txt")
+	indexPath := filepath.Join(dir, "users.idx")
+	if err := ioutil.WriteFile(dataPath, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenIndex(indexPath, dataPath); err != nil {
+		t.Fatal(err)
+	}
+	// Save заменяет файл через rename, так что перезапись видна по inode
+	saved := func() os.FileInfo {
+		info, err := os.Stat(indexPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+	before := saved()
+	if _, err := OpenIndex(indexPath, dataPath); err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, saved()) {
+		t.Error("unchanged index was saved again")
+	}
+	if err := ioutil.WriteFile(dataPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenIndex(indexPath, dataPath); err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, saved()) {
+		t.Error("updated index was not saved")
+	}
+}
+
+func BenchmarkIndex(b *testing.B) {
+	idx, err := BuildIndex(filePath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	opts := SearchOptions{Query: defaultQuery}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		IndexSearch(ioutil.Discard, idx, opts)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Compression of a dataset detected by its magic bytes.
+type Compression int
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZstd
+)
+
+// newZstdReader decompresses zstd input. The standard library has no zstd
+// decoder, so it is only set in builds with -tags zstd, which need
+// github.com/klauspost/compress in GOPATH (see input_zstd.go).
+var newZstdReader func(r io.Reader) (io.Reader, func(), error)
+
+var errNoZstd = errors.New("zstd input is not supported by this build, rebuild with -tags zstd")
+
+// DetectCompression peeks at the first bytes of r without consuming them.
+func DetectCompression(r *bufio.Reader) Compression {
+	magic, _ := r.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(magic, zstdMagic):
+		return CompressionZstd
+	}
+	return CompressionNone
+}
+
+// NewDatasetReader returns a buffered reader of the plain dataset,
+// transparently decompressing gzip and, in zstd builds, zstd input.
+// The returned function releases decoder resources, it does not close r.
+func NewDatasetReader(r io.Reader) (*bufio.Reader, func(), error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	switch DetectCompression(br) {
+	case CompressionGzip:
+		dec, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bufio.NewReaderSize(dec, 64*1024), func() { dec.Close() }, nil
+	case CompressionZstd:
+		if newZstdReader == nil {
+			return nil, nil, errNoZstd
+		}
+		dec, release, err := newZstdReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bufio.NewReaderSize(dec, 64*1024), release, nil
+	}
+	return br, func() {}, nil
+}
+
+// SearchFile is Search over a possibly compressed file.
+func SearchFile(out io.Writer, path string, opts SearchOptions) (*SearchReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Search(out, file, opts)
+}
+
+// ScanSearchFile is ScanSearch over a possibly compressed file.
+func ScanSearchFile(out io.Writer, path string, opts SearchOptions) (*SearchReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ScanSearch(out, file, opts)
+}
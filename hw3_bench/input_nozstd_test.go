@@ -0,0 +1,16 @@
+//go:build !zstd
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestZstdNotBuilt(t *testing.T) {
+	content := append(append([]byte{}, zstdMagic...), 0, 0, 0, 0)
+	if _, err := Search(ioutil.Discard, bytes.NewReader(content), SearchOptions{Query: defaultQuery}); err != errNoZstd {
+		t.Errorf("expected errNoZstd, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// checkCompressed runs every search over content and compares results
+// with FastSearch over the plain dataset.
+func checkCompressed(t *testing.T, name string, content []byte) {
+	expected := new(bytes.Buffer)
+	if err := FastSearch(expected); err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	if _, err := Search(out, bytes.NewReader(content), SearchOptions{Query: defaultQuery}); err != nil {
+		t.Errorf("[%s] unexpected error: %s", name, err)
+	}
+	if out.String() != expected.String() {
+		t.Errorf("[%s] results not match\nGot:\n%v\nExpected:\n%v", name, out.String(), expected.String())
+	}
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, search := range []func() (*SearchReport, error){
+		func() (*SearchReport, error) { return ScanSearchFile(out, path, SearchOptions{Query: defaultQuery}) },
+		func() (*SearchReport, error) { return ParallelSearch(out, path, SearchOptions{Query: defaultQuery}, 4) },
+	} {
+		out.Reset()
+		if _, err := search(); err != nil {
+			t.Errorf("[%s] unexpected error: %s", name, err)
+		}
+		if out.String() != expected.String() {
+			t.Errorf("[%s] file results not match", name)
+		}
+	}
+}
+
+func TestCompressedInput(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := new(bytes.Buffer)
+	gzw := gzip.NewWriter(gz)
+	gzw.Write(data)
+	gzw.Close()
+
+	checkCompressed(t, "plain", data)
+	checkCompressed(t, "gzip", gz.Bytes())
+
+	if _, err := Search(ioutil.Discard, bytes.NewReader(gz.Bytes()[:20]), SearchOptions{Query: defaultQuery}); err == nil {
+		t.Errorf("expected error for truncated gzip, got nil")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build zstd
+
+package main
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func init() {
+	newZstdReader = func(r io.Reader) (io.Reader, func(), error) {
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return dec, dec.Close, nil
+	}
+}
@@ -0,0 +1,26 @@
+//go:build zstd
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestZstdInput(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zst := new(bytes.Buffer)
+	zw, err := zstd.NewWriter(zst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(data)
+	zw.Close()
+	checkCompressed(t, "zstd", zst.Bytes())
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"runtime/debug"
+)
+
+var errMmapUnsupported = errors.New("mmap is not supported")
+
+var errMappedFileChanged = errors.New("dataset was truncated during the search")
+
+// MmapSearch is SearchFile scanning a memory-mapped file in place instead
+// of copying it through a bufio.Reader. Compressed and empty files and
+// platforms without mmap (anything but Linux) fall back to SearchFile.
+//
+// The dataset must not be truncated during the search: the lost part of
+// the mapping can not be read anymore. MmapSearch turns the resulting
+// fault into errMappedFileChanged, but results already written to out stay.
+func MmapSearch(out io.Writer, path string, opts SearchOptions) (report *SearchReport, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if DetectCompression(bufio.NewReaderSize(file, 16)) != CompressionNone {
+		return searchFrom(out, file, opts)
+	}
+	data, unmap, err := mapFile(file, info.Size())
+	if err != nil {
+		return searchFrom(out, file, opts)
+	}
+	defer unmap()
+	// без SetPanicOnFault SIGBUS на отображенной памяти роняет процесс,
+	// а так это паника только этой горутины, ее и ловим
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			if _, fault := r.(interface{ Addr() uintptr }); !fault {
+				panic(r)
+			}
+			report, err = nil, errMappedFileChanged
+		}
+	}()
+	return searchLines(out, mappedLines(data), opts)
+}
+
+// searchFrom rewinds file and searches it with the buffered reader.
+func searchFrom(out io.Writer, file *os.File, opts SearchOptions) (*SearchReport, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return Search(out, file, opts)
+}
+
+// mappedLines returns lines of data without copying them, like readLine
+// it strips "\n" and "\r\n".
+func mappedLines(data []byte) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		if len(data) == 0 {
+			return nil, io.EOF
+		}
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		if n := len(line); n > 0 && line[n-1] == '\r' {
+			line = line[:n-1]
+		}
+		return line, nil
+	}
+}
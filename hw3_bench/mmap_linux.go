@@ -0,0 +1,29 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps size bytes of file read-only. The returned function unmaps
+// it, the data must not be used after that. If the file is truncated while
+// mapped, reading past its new end raises SIGBUS, see MmapSearch.
+func mapFile(file *os.File, size int64) ([]byte, func() error, error) {
+	if size <= 0 || int64(int(size)) != size {
+		return nil, nil, errMmapUnsupported
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, nil, err
+	}
+	// файл мог измениться между Stat и Mmap, тогда лучше читать как обычно
+	if info, err := file.Stat(); err != nil || info.Size() != size {
+		syscall.Munmap(data)
+		return nil, nil, errMmapUnsupported
+	}
+	// читаем подряд, пусть ядро читает вперед побольше
+	syscall.Madvise(data, syscall.MADV_SEQUENTIAL)
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
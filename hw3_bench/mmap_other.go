@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+)
+
+func mapFile(file *os.File, size int64) ([]byte, func() error, error) {
+	return nil, nil, errMmapUnsupported
+}
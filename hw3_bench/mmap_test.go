@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestMmapSearch(t *testing.T) {
+	fastOut := new(bytes.Buffer)
+	FastSearch(fastOut)
+	out := new(bytes.Buffer)
+	if _, err := MmapSearch(out, filePath, SearchOptions{Query: defaultQuery}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != fastOut.String() {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", out.String(), fastOut.String())
+	}
+
+	// сжатый файл не отображается, а читается как обычно
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzPath := filepath.Join(t.TempDir(), "users.txt.gz")
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	zw.Write(data)
+	zw.Close()
+	if err := ioutil.WriteFile(gzPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if _, err := MmapSearch(out, gzPath, SearchOptions{Query: defaultQuery}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != fastOut.String() {
+		t.Errorf("gzip results not match\nGot:\n%v\nExpected:\n%v", out.String(), fastOut.String())
+	}
+
+	emptyPath := filepath.Join(t.TempDir(), "empty.txt")
+	ioutil.WriteFile(emptyPath, nil, 0644)
+	out.Reset()
+	report, err := MmapSearch(out, emptyPath, SearchOptions{Query: defaultQuery})
+	if err != nil || report.Lines != 0 || out.String() != "found users:\n\nTotal unique browsers 0\n" {
+		t.Errorf("empty file: %v %+v %q", err, report, out)
+	}
+}
+
+func TestMappedLines(t *testing.T) {
+	next := mappedLines([]byte("a\r\n\nbb\nc"))
+	expected := []string{"a", "", "bb", "c"}
+	for _, e := range expected {
+		line, err := next()
+		if err != nil || string(line) != e {
+			t.Fatalf("got %q %v, expected %q", line, err, e)
+		}
+	}
+	if _, err := next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	next = mappedLines([]byte("x\n"))
+	if line, _ := next(); string(line) != "x" {
+		t.Errorf("got %q", line)
+	}
+	if _, err := next(); err != io.EOF {
+		t.Errorf("trailing newline: expected EOF, got %v", err)
+	}
+}
+
+// truncatingWriter truncates path on the first write.
+type truncatingWriter struct {
+	path string
+	done bool
+}
+
+func (w *truncatingWriter) Write(p []byte) (int, error) {
+	if !w.done {
+		w.done = true
+		if err := os.Truncate(w.path, 0); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func TestMmapSearchTruncated(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mmap is used on Linux only")
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.txt")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// файл усекается при выводе заголовка, до чтения первой строки
+	if _, err := MmapSearch(&truncatingWriter{path: path}, path, SearchOptions{Query: defaultQuery}); err != errMappedFileChanged {
+		t.Errorf("expected errMappedFileChanged, got %v", err)
+	}
+}
+
+// BenchmarkReaders compares the buffered and the mmap input paths on a
+// generated dataset. HW3_BENCH_LINES sets its size (100k lines, ~50MB by
+// default). Warm runs read the file from the page cache, Cold ones evict it
+// with posix_fadvise before every run and are skipped where that is not
+// supported.
+func BenchmarkReaders(b *testing.B) {
+	lines := 100000
+	if s := os.Getenv("HW3_BENCH_LINES"); s != "" {
+		n, err := ParseCount(s)
+		if err != nil {
+			b.Fatal(err)
+		}
+		lines = n
+	}
+	path := filepath.Join(b.TempDir(), "users_"+strconv.Itoa(lines)+".txt")
+	if err := generateFile(path, lines, DefaultGenerateOptions(lines, 1)); err != nil {
+		b.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	searches := []struct {
+		name   string
+		search func(io.Writer, string, SearchOptions) (*SearchReport, error)
+	}{
+		{"Buffered", SearchFile},
+		{"Mmap", MmapSearch},
+	}
+	for _, s := range searches {
+		for _, cold := range []bool{false, true} {
+			name := s.name + "/Warm"
+			if cold {
+				name = s.name + "/Cold"
+			}
+			b.Run(name, func(b *testing.B) {
+				if cold {
+					if err := evictFile(path); err != nil {
+						b.Skip(err)
+					}
+				}
+				b.SetBytes(info.Size())
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if cold {
+						b.StopTimer()
+						if err := evictFile(path); err != nil {
+							b.Fatal(err)
+						}
+						b.StartTimer()
+					}
+					if _, err := s.search(ioutil.Discard, path, SearchOptions{Query: defaultQuery}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// ParallelSearch is SearchFile splitting the file into newline aligned
+// byte ranges scanned by workers goroutines (GOMAXPROCS if workers < 1).
+// Output is identical to Search: matches keep their original line order.
+// Compressed files can not be split and are searched sequentially.
+func ParallelSearch(out io.Writer, path string, opts SearchOptions, workers int) (*SearchReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if DetectCompression(bufio.NewReaderSize(file, 16)) != CompressionNone {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return Search(out, file, opts)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	bounds, err := chunkBounds(file, info.Size(), workers)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]*searchChunk, len(bounds)-1)
+	wg := &sync.WaitGroup{}
+	for i := range chunks {
+		chunks[i] = &searchChunk{}
+		wg.Add(1)
+		go func(c *searchChunk, start, end int64) {
+			defer wg.Done()
+			c.scan(io.NewSectionReader(file, start, end-start), opts)
+		}(chunks[i], bounds[i], bounds[i+1])
+	}
+	wg.Wait()
+
+	format := opts.formatter()
+	report := &SearchReport{}
+	seenBrowsers := make(map[string]bool)
+	fmt.Fprintln(out, "found users:")
+	offset := 0
+	for _, c := range chunks {
+		for i := range c.found {
+			format(out, offset+c.found[i].index, &c.found[i].user)
+		}
+		report.Lines += c.lines
+		report.Matched += len(c.found)
+		for _, lerr := range c.skipped {
+			lerr.Line += offset
+			report.Skipped = append(report.Skipped, lerr)
+		}
+		if c.err != nil {
+			if lerr, ok := c.err.(*LineError); ok {
+				lerr.Line += offset
+			}
+			return report, c.err
+		}
+		for browser := range c.seen {
+			seenBrowsers[browser] = true
+		}
+		offset += c.lines
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Total unique browsers", len(seenBrowsers))
+	return report, nil
+}
+
+type foundUser struct {
+	// index is the line number inside the chunk
+	index int
+	user  User
+}
+
+type searchChunk struct {
+	lines   int
+	found   []foundUser
+	seen    map[string]bool
+	skipped []*LineError
+	// err stops the chunk, line numbers are local to the chunk
+	err error
+}
+
+func (c *searchChunk) scan(r io.Reader, opts SearchOptions) {
+	reader := bufio.NewReader(r)
+	scanner := newUserScanner(opts)
+	chunkReport := &SearchReport{}
+	var scratch []byte
+	for ; ; c.lines++ {
+		line, err := readLine(reader, &scratch)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.err = err
+			break
+		}
+		ok, err := scanner.scanLine(line)
+		if err != nil {
+			if c.err = chunkReport.skip(c.lines, err, opts.Tolerant); c.err != nil {
+				break
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+		user := scanner.user
+		user.Browsers = append([]string(nil), user.Browsers...)
+		c.found = append(c.found, foundUser{index: c.lines, user: user})
+	}
+	c.seen = scanner.seen
+	c.skipped = chunkReport.Skipped
+}
+
+// chunkBounds splits [0, size) into at most n ranges, every range but the
+// first starting right after a newline. It returns n+1 or fewer offsets.
+func chunkBounds(r io.ReaderAt, size int64, n int) ([]int64, error) {
+	bounds := []int64{0}
+	buf := make([]byte, 4096)
+	for k := 1; k < n; k++ {
+		pos := size * int64(k) / int64(n)
+		if prev := bounds[len(bounds)-1]; pos <= prev {
+			continue
+		}
+		// ищем перевод строки начиная с pos-1, чтобы не пропустить строку,
+		// начинающуюся ровно на pos
+		next := size
+		for at := pos - 1; at < size; at += int64(len(buf)) {
+			read, err := r.ReadAt(buf, at)
+			if idx := bytes.IndexByte(buf[:read], '\n'); idx >= 0 {
+				next = at + int64(idx) + 1
+				break
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if next >= size {
+			break
+		}
+		if next > bounds[len(bounds)-1] {
+			bounds = append(bounds, next)
+		}
+	}
+	return append(bounds, size), nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestParallelSearch(t *testing.T) {
+	fastOut := new(bytes.Buffer)
+	if err := FastSearch(fastOut); err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{0, 1, 2, 3, 7, 64, 5000} {
+		out := new(bytes.Buffer)
+		if _, err := ParallelSearch(out, filePath, SearchOptions{Query: defaultQuery}, workers); err != nil {
+			t.Fatalf("[%d] unexpected error: %s", workers, err)
+		}
+		if out.String() != fastOut.String() {
+			t.Errorf("[%d] results not match\nGot:\n%v\nExpected:\n%v", workers, out.String(), fastOut.String())
+		}
+	}
+}
+
+func TestChunkBounds(t *testing.T) {
+	data := "aa\nb\n\ncccc\nd"
+	for n := 1; n < 20; n++ {
+		bounds, err := chunkBounds(strings.NewReader(data), int64(len(data)), n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bounds[0] != 0 || bounds[len(bounds)-1] != int64(len(data)) || len(bounds) > n+1 {
+			t.Errorf("[%d] bad bounds %v", n, bounds)
+		}
+		for i := 1; i < len(bounds)-1; i++ {
+			if data[bounds[i]-1] != '\n' || bounds[i] <= bounds[i-1] {
+				t.Errorf("[%d] bound %d not aligned: %v", n, bounds[i], bounds)
+			}
+		}
+	}
+}
+
+func BenchmarkParallel(b *testing.B) {
+	opts := SearchOptions{Query: defaultQuery}
+	for i := 0; i < b.N; i++ {
+		ParallelSearch(ioutil.Discard, filePath, opts, 0)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Query is a compiled boolean expression over User fields, e.g.
+//
+//	browsers~Android AND browsers~MSIE AND country=Peru
+//	(name~/^J/ OR email~muxo) AND NOT country="Dominican Republic"
+//
+// field~value matches a substring, field~/re/ a regexp and field=value
+// the whole field. For browsers a predicate holds if any browser matches.
+type Query struct {
+	src    string
+	root   queryNode
+	leaves []*predicate
+	fields fieldMask
+}
+
+type fieldMask uint8
+
+const (
+	fieldBrowsers fieldMask = 1 << iota
+	fieldCompany
+	fieldCountry
+	fieldEmail
+	fieldJob
+	fieldName
+	fieldPhone
+)
+
+var queryFields = map[string]fieldMask{
+	"browsers": fieldBrowsers,
+	"company":  fieldCompany,
+	"country":  fieldCountry,
+	"email":    fieldEmail,
+	"job":      fieldJob,
+	"name":     fieldName,
+	"phone":    fieldPhone,
+}
+
+func (f fieldMask) String() string {
+	for name, field := range queryFields {
+		if field == f {
+			return name
+		}
+	}
+	return fmt.Sprintf("fieldMask(%d)", uint8(f))
+}
+
+func (f fieldMask) get(u *User) string {
+	switch f {
+	case fieldCompany:
+		return u.Company
+	case fieldCountry:
+		return u.Country
+	case fieldEmail:
+		return u.Email
+	case fieldJob:
+		return u.Job
+	case fieldName:
+		return u.Name
+	case fieldPhone:
+		return u.Phone
+	}
+	return ""
+}
+
+type predicate struct {
+	field fieldMask
+	op    byte
+	value string
+	// bvalue is value for matching byte slices
+	bvalue []byte
+	re     *regexp.Regexp
+	index  int
+}
+
+func (p *predicate) matchString(s string) bool {
+	switch {
+	case p.re != nil:
+		return p.re.MatchString(s)
+	case p.op == '=':
+		return s == p.value
+	default:
+		return strings.Contains(s, p.value)
+	}
+}
+
+type queryNode interface {
+	eval(leaves []bool) bool
+}
+
+type leafNode int
+type notNode struct{ node queryNode }
+type andNode []queryNode
+type orNode []queryNode
+
+func (n leafNode) eval(leaves []bool) bool { return leaves[n] }
+func (n notNode) eval(leaves []bool) bool  { return !n.node.eval(leaves) }
+
+func (n andNode) eval(leaves []bool) bool {
+	for _, c := range n {
+		if !c.eval(leaves) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n orNode) eval(leaves []bool) bool {
+	for _, c := range n {
+		if c.eval(leaves) {
+			return true
+		}
+	}
+	return false
+}
+
+// CompileQuery parses a query. Operators are AND, OR and NOT (in order of
+// increasing precedence), parentheses group. Values containing spaces or
+// parentheses have to be double-quoted.
+func CompileQuery(src string) (*Query, error) {
+	tokens, err := lexQuery(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens, q: &Query{src: src}}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("query: unexpected %q", p.tokens[p.pos])
+	}
+	p.q.root = root
+	return p.q, nil
+}
+
+// MustCompileQuery is like CompileQuery but panics on error.
+func MustCompileQuery(src string) *Query {
+	q, err := CompileQuery(src)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func (q *Query) String() string {
+	return q.src
+}
+
+// Match reports whether the user satisfies the query.
+func (q *Query) Match(u *User) bool {
+	return q.match(u, make([]bool, len(q.leaves)), nil)
+}
+
+// match evaluates the query using leaves as scratch space. Every browser
+// matched by some browsers predicate is recorded in seen if it is not nil,
+// regardless of the overall result.
+func (q *Query) match(u *User, leaves []bool, seen map[string]bool) bool {
+	for _, p := range q.leaves {
+		leaves[p.index] = false
+		if p.field != fieldBrowsers {
+			leaves[p.index] = p.matchString(p.field.get(u))
+			continue
+		}
+		for _, browser := range u.Browsers {
+			if !p.matchString(browser) {
+				continue
+			}
+			leaves[p.index] = true
+			if seen == nil {
+				break
+			}
+			if !seen[browser] {
+				seen[browser] = true
+			}
+		}
+	}
+	return q.root.eval(leaves)
+}
+
+func lexQuery(src string) ([]string, error) {
+	tokens := []string{}
+	for i := 0; i < len(src); {
+		switch c := src[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, src[i:i+1])
+			i++
+		default:
+			start := i
+			afterOp := false
+			for i < len(src) && src[i] != ' ' && src[i] != '\t' && src[i] != '(' && src[i] != ')' {
+				var quote byte
+				switch {
+				case src[i] == '"':
+					quote = '"'
+				case src[i] == '/' && afterOp:
+					quote = '/'
+				}
+				afterOp = src[i] == '~' || src[i] == '='
+				if quote == 0 {
+					i++
+					continue
+				}
+				i++
+				for i < len(src) && src[i] != quote {
+					if src[i] == '\\' {
+						i++
+					}
+					i++
+				}
+				if i >= len(src) {
+					return nil, fmt.Errorf("query: unterminated %c in %q", quote, src[start:])
+				}
+				i++
+			}
+			tokens = append(tokens, src[start:i])
+		}
+	}
+	return tokens, nil
+}
+
+type queryParser struct {
+	tokens []string
+	pos    int
+	q      *Query
+}
+
+func (p *queryParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	nodes := orNode{}
+	for {
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+		if p.peek() != "OR" {
+			break
+		}
+		p.pos++
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	nodes := andNode{}
+	for {
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+		if p.peek() != "AND" {
+			break
+		}
+		p.pos++
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	tok := p.peek()
+	p.pos++
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("query: unexpected end")
+	case "NOT":
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	case "(":
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("query: missing )")
+		}
+		p.pos++
+		return n, nil
+	case ")", "AND", "OR":
+		return nil, fmt.Errorf("query: unexpected %q", tok)
+	}
+	return p.parsePredicate(tok)
+}
+
+func (p *queryParser) parsePredicate(tok string) (queryNode, error) {
+	opPos := strings.IndexAny(tok, "~=")
+	if opPos <= 0 {
+		return nil, fmt.Errorf("query: bad predicate %q, want field~value or field=value", tok)
+	}
+	field, ok := queryFields[tok[:opPos]]
+	if !ok {
+		return nil, fmt.Errorf("query: unknown field %q", tok[:opPos])
+	}
+	pred := &predicate{field: field, op: tok[opPos], value: tok[opPos+1:], index: len(p.q.leaves)}
+	switch {
+	case strings.HasPrefix(pred.value, `"`):
+		value, err := strconv.Unquote(pred.value)
+		if err != nil {
+			return nil, fmt.Errorf("query: bad quoted value %s", pred.value)
+		}
+		pred.value = value
+	case pred.op == '~' && len(pred.value) >= 2 && pred.value[0] == '/' && pred.value[len(pred.value)-1] == '/':
+		re, err := regexp.Compile(strings.Replace(pred.value[1:len(pred.value)-1], `\/`, `/`, -1))
+		if err != nil {
+			return nil, fmt.Errorf("query: %s", err)
+		}
+		pred.re = re
+	}
+	pred.bvalue = []byte(pred.value)
+	p.q.leaves = append(p.q.leaves, pred)
+	p.q.fields |= field
+	return leafNode(pred.index), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCompileQuery(t *testing.T) {
+	user := &User{
+		Browsers: []string{"Mozilla/5.0 (Linux; Android 4.4.2)", "Mozilla/4.0 (compatible; MSIE 6.0)"},
+		Country:  "Dominican Republic",
+		Email:    "JonathanMorris@Muxo.edu",
+		Name:     "Sharon Crawford",
+	}
+	cases := []struct {
+		query string
+		match bool
+	}{
+		{"browsers~Android AND browsers~MSIE", true},
+		{"browsers~Android AND browsers~Opera", false},
+		{"browsers~Opera OR name~Sharon", true},
+		{`country="Dominican Republic" AND NOT email~gmail`, true},
+		{"country=Dominican", false},
+		{"country~Dominican", true},
+		{`browsers~/MSIE [0-9]\.0/`, true},
+		{`name~/^Crawford/`, false},
+		{"NOT (browsers~Android OR browsers~Opera)", false},
+		{"browsers~Opera OR browsers~Safari OR name~Crawford AND email~muxo", false},
+	}
+	for _, c := range cases {
+		q, err := CompileQuery(c.query)
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", c.query, err)
+			continue
+		}
+		if got := q.Match(user); got != c.match {
+			t.Errorf("[%s] expected %v, got %v", c.query, c.match, got)
+		}
+	}
+
+	for _, bad := range []string{"", "browsers", "age=1", "(name~a", "name~a AND", `name~"a`, "name~/(/", "name~a )"} {
+		if _, err := CompileQuery(bad); err == nil {
+			t.Errorf("[%s] expected error, got nil", bad)
+		}
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	q, err := CompileQuery("browsers~Android AND browsers~MSIE OR country=Peru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	_, err = SearchFile(out, filePath, SearchOptions{
+		Query: q,
+		Format: func(out io.Writer, index int, user *User) {
+			fmt.Fprintln(out, index, user.Country)
+		},
+		Fields: []string{"country"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "found users:\n"
+	for i, line := range strings.Split(string(data), "\n") {
+		user := &User{}
+		if err := user.UnmarshalJSON([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		if q.Match(user) {
+			expected += fmt.Sprintln(i, user.Country)
+		}
+	}
+	if !strings.HasPrefix(out.String(), expected) || !strings.Contains(expected, "Peru") {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", out.String(), expected)
+	}
+}
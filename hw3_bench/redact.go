@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// RedactPolicy says how a PII field is shown in search output.
+type RedactPolicy int
+
+const (
+	// RedactKeep shows the value as is
+	RedactKeep RedactPolicy = iota
+	// RedactObfuscate replaces @ with " [at] ", the historical output
+	RedactObfuscate
+	// RedactMask keeps the first letter of every word (and the domain of
+	// an email, the last two digits of a phone): j***@muxo.edu
+	RedactMask
+	// RedactHash replaces the value with a salted SHA-256 prefix, equal
+	// values stay equal so results can still be joined
+	RedactHash
+	// RedactDrop leaves the field out
+	RedactDrop
+)
+
+var redactPolicyNames = []string{"keep", "obfuscate", "mask", "hash", "drop"}
+
+func (p RedactPolicy) String() string {
+	if p >= 0 && int(p) < len(redactPolicyNames) {
+		return redactPolicyNames[p]
+	}
+	return "RedactPolicy(" + strconv.Itoa(int(p)) + ")"
+}
+
+// ParseRedactPolicy parses a policy name as printed by String.
+func ParseRedactPolicy(s string) (RedactPolicy, error) {
+	for i, name := range redactPolicyNames {
+		if s == name {
+			return RedactPolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown redaction policy %q, want one of %s", s, strings.Join(redactPolicyNames, ", "))
+}
+
+// Redactor applies a policy per PII field. Its Format method is a
+// ResultFormatter printing "[index] name <email> phone" with dropped
+// fields left out.
+type Redactor struct {
+	Name  RedactPolicy
+	Email RedactPolicy
+	Phone RedactPolicy
+	// Salt is mixed into hashes so they can not be reversed with a
+	// dictionary of known emails
+	Salt string
+}
+
+// DefaultRedactor reproduces DefaultFormat.
+var DefaultRedactor = Redactor{Email: RedactObfuscate, Phone: RedactDrop}
+
+// Fields lists the User fields Format needs besides name and email, for
+// SearchOptions.Fields.
+func (r *Redactor) Fields() []string {
+	if r.Phone == RedactDrop {
+		return nil
+	}
+	return []string{"phone"}
+}
+
+// policy returns the policy of a query field, fields without one are
+// shown as is.
+func (r *Redactor) policy(field fieldMask) RedactPolicy {
+	switch field {
+	case fieldName:
+		return r.Name
+	case fieldEmail:
+		return r.Email
+	case fieldPhone:
+		return r.Phone
+	}
+	return RedactKeep
+}
+
+// CheckQuery returns an error if q has predicates on fields r does not
+// keep: name~/^A/ and the like would reveal what redaction hides.
+func (r *Redactor) CheckQuery(q *Query) error {
+	for _, p := range q.leaves {
+		if policy := r.policy(p.field); policy != RedactKeep {
+			return fmt.Errorf("field %s is redacted (%s) and can not be queried", p.field, policy)
+		}
+	}
+	return nil
+}
+
+// Redact returns a copy of u with policies applied, dropped fields are
+// empty.
+func (r *Redactor) Redact(u *User) User {
+	res := *u
+	res.Name = r.apply(r.Name, u.Name, maskWords)
+	res.Email = r.apply(r.Email, u.Email, maskEmail)
+	res.Phone = r.apply(r.Phone, u.Phone, maskPhone)
+	return res
+}
+
+func (r *Redactor) apply(p RedactPolicy, value string, mask func(string) string) string {
+	switch p {
+	case RedactObfuscate:
+		return ObfuscateEmail(value)
+	case RedactMask:
+		return mask(value)
+	case RedactHash:
+		sum := sha256.Sum256([]byte(r.Salt + "\x00" + value))
+		return hex.EncodeToString(sum[:8])
+	case RedactDrop:
+		return ""
+	}
+	return value
+}
+
+// Format prints a found user like DefaultFormat does, redacted.
+func (r *Redactor) Format(out io.Writer, index int, user *User) {
+	u := r.Redact(user)
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '[')
+	buf = strconv.AppendInt(buf, int64(index), 10)
+	buf = append(buf, ']')
+	if r.Name != RedactDrop {
+		buf = append(buf, ' ')
+		buf = append(buf, u.Name...)
+	}
+	if r.Email != RedactDrop {
+		buf = append(buf, " <"...)
+		buf = append(buf, u.Email...)
+		buf = append(buf, '>')
+	}
+	if r.Phone != RedactDrop {
+		buf = append(buf, ' ')
+		buf = append(buf, u.Phone...)
+	}
+	out.Write(append(buf, '\n'))
+}
+
+// maskWords keeps the first letter of every word: "Sharon Crawford"
+// becomes "S*** C***". Masks have a fixed length to hide the original one.
+func maskWords(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		words[i] = firstRune(w) + "***"
+	}
+	return strings.Join(words, " ")
+}
+
+// maskEmail keeps the first letter of the local part and the domain,
+// lowercased: JonathanMorris@Muxo.edu becomes j***@muxo.edu.
+func maskEmail(s string) string {
+	at := strings.LastIndexByte(s, '@')
+	if at < 0 {
+		return maskWords(s)
+	}
+	return strings.ToLower(firstRune(s[:at]) + "***@" + s[at+1:])
+}
+
+// maskPhone replaces all digits but the last two: 176-88-49 becomes
+// ***-**-49.
+func maskPhone(s string) string {
+	digits := 0
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+	b := []byte(s)
+	for i, c := range b {
+		if c >= '0' && c <= '9' && digits > 2 {
+			b[i] = '*'
+			digits--
+		}
+	}
+	return string(b)
+}
+
+func firstRune(s string) string {
+	for _, c := range s {
+		return string(c)
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	user := &User{Name: "Sharon Crawford", Email: "JonathanMorris@Muxo.edu", Phone: "176-88-49"}
+	cases := []struct {
+		r        Redactor
+		expected string
+	}{
+		{DefaultRedactor, "[7] Sharon Crawford <JonathanMorris [at] Muxo.edu>\n"},
+		{Redactor{Name: RedactMask, Email: RedactMask, Phone: RedactMask}, "[7] S*** C*** <j***@muxo.edu> ***-**-49\n"},
+		{Redactor{Name: RedactDrop, Email: RedactKeep, Phone: RedactKeep}, "[7] <JonathanMorris@Muxo.edu> 176-88-49\n"},
+		{Redactor{Name: RedactDrop, Email: RedactDrop, Phone: RedactDrop}, "[7]\n"},
+	}
+	for _, c := range cases {
+		out := new(bytes.Buffer)
+		c.r.Format(out, 7, user)
+		if out.String() != c.expected {
+			t.Errorf("%+v: got %q, expected %q", c.r, out, c.expected)
+		}
+	}
+
+	defaultOut := new(bytes.Buffer)
+	DefaultFormat(defaultOut, 7, user)
+	if defaultOut.String() != cases[0].expected {
+		t.Errorf("DefaultRedactor differs from DefaultFormat: %q", defaultOut)
+	}
+
+	hashed := Redactor{Email: RedactHash, Salt: "pepper"}
+	other := Redactor{Email: RedactHash, Salt: "salt"}
+	h1, h2 := hashed.Redact(user).Email, hashed.Redact(user).Email
+	if h1 != h2 || len(h1) != 16 || strings.Contains(h1, "Muxo") {
+		t.Errorf("bad hash %q %q", h1, h2)
+	}
+	if other.Redact(user).Email == h1 {
+		t.Error("hash does not depend on salt")
+	}
+	if user.Email != "JonathanMorris@Muxo.edu" {
+		t.Error("Redact modified the user")
+	}
+}
+
+func TestParseRedactPolicy(t *testing.T) {
+	for _, p := range []RedactPolicy{RedactKeep, RedactObfuscate, RedactMask, RedactHash, RedactDrop} {
+		if parsed, err := ParseRedactPolicy(p.String()); err != nil || parsed != p {
+			t.Errorf("%v: parsed %v, %v", p, parsed, err)
+		}
+	}
+	if _, err := ParseRedactPolicy("blur"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+	if _, err := newRedactor("keep", "hash", "drop", ""); err == nil {
+		t.Error("expected error for hash without salt")
+	}
+	if _, err := newRedactor("keep", "hash", "drop", "pepper"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestSearchRedacted(t *testing.T) {
+	withDataset(t, `{"browsers":["Android","MSIE"],"name":"Ann Lee","email":"ann@x.org","phone":"123-45-67"}`)
+	r := &Redactor{Name: RedactMask, Email: RedactMask, Phone: RedactMask}
+	out := new(bytes.Buffer)
+	_, err := SearchFile(out, filePath, SearchOptions{Query: defaultQuery, Format: r.Format, Fields: r.Fields()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "found users:\n[0] A*** L*** <a***@x.org> ***-**-67\n\nTotal unique browsers 2\n"
+	if out.String() != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", out, expected)
+	}
+
+	ds, err := loadDataset(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := ds.search(defaultQuery, 0, 10, r)
+	if len(res.Users) != 1 || res.Users[0] != (SearchMatch{Index: 0, Name: "A*** L***", Email: "a***@x.org", Phone: "***-**-67"}) {
+		t.Errorf("wrong server result: %+v", res.Users)
+	}
+}
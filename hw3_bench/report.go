@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// LineError is a line of the dataset that could not be decoded.
+type LineError struct {
+	// Line is 1-based, the [index] printed for found users is Line-1
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// SearchReport summarises a search run.
+type SearchReport struct {
+	Lines   int
+	Matched int
+	// Skipped lists malformed lines in tolerant mode
+	Skipped []*LineError
+}
+
+// skip records a bad line at index. In strict mode it returns the error
+// the search has to stop with.
+func (r *SearchReport) skip(index int, err error, tolerant bool) error {
+	lerr := &LineError{Line: index + 1, Err: err}
+	if !tolerant {
+		return lerr
+	}
+	r.Skipped = append(r.Skipped, lerr)
+	return nil
+}
+
+// WriteSummary prints the totals and every skipped line.
+func (r *SearchReport) WriteSummary(w io.Writer) {
+	fmt.Fprintf(w, "lines: %d, matched: %d, skipped: %d\n", r.Lines, r.Matched, len(r.Skipped))
+	for _, lerr := range r.Skipped {
+		fmt.Fprintln(w, "  skipped", lerr)
+	}
+}
+
+// readLine returns the next line without the trailing newline. Unlike
+// bufio.Reader.ReadLine it does not split long lines, they are glued
+// together in scratch. The result is valid until the next call.
+func readLine(r *bufio.Reader, scratch *[]byte) ([]byte, error) {
+	line, isPrefix, err := r.ReadLine()
+	if err != nil || !isPrefix {
+		return line, err
+	}
+	buf := append((*scratch)[:0], line...)
+	for isPrefix {
+		line, isPrefix, err = r.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, line...)
+	}
+	*scratch = buf
+	return buf, nil
+}
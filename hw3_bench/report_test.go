@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withDataset подменяет filePath на временный файл с данными
+func withDataset(t *testing.T, data string) {
+	path := filepath.Join(t.TempDir(), "users.txt")
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prev := filePath
+	filePath = path
+	t.Cleanup(func() { filePath = prev })
+}
+
+// lineSearches are the searches that report malformed lines, over filePath
+var lineSearches = map[string]func(io.Writer, bool) (*SearchReport, error){
+	"fast": func(out io.Writer, tolerant bool) (*SearchReport, error) {
+		return SearchFile(out, filePath, SearchOptions{Query: defaultQuery, Tolerant: tolerant})
+	},
+	"parallel": func(out io.Writer, tolerant bool) (*SearchReport, error) {
+		return ParallelSearch(out, filePath, SearchOptions{Query: defaultQuery, Tolerant: tolerant}, 3)
+	},
+	"scan": func(out io.Writer, tolerant bool) (*SearchReport, error) {
+		return ScanSearchFile(out, filePath, SearchOptions{Query: defaultQuery, Tolerant: tolerant})
+	},
+	"slow": SlowSearchReport,
+}
+
+func TestSearchMalformed(t *testing.T) {
+	long := `{"browsers":["` + strings.Repeat("x", 10000) + ` Android","MSIE"],"name":"Long","email":"l@x"}`
+	withDataset(t, strings.Join([]string{
+		`{"browsers":["Android","MSIE 6"],"name":"A","email":"a@x"}`,
+		`{"browsers":["Android"`,
+		``,
+		long,
+		`{"browsers":["MSIE 7","Android 4"],"name":"B","email":"b@x"}`,
+	}, "\n"))
+
+	expected := "found users:\n[0] A <a [at] x>\n[3] Long <l [at] x>\n[4] B <b [at] x>\n\nTotal unique browsers 6\n"
+	for name, search := range lineSearches {
+		out := new(bytes.Buffer)
+		report, err := search(out, true)
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", name, err)
+			continue
+		}
+		if out.String() != expected {
+			t.Errorf("[%s] results not match\nGot:\n%v\nExpected:\n%v", name, out.String(), expected)
+		}
+		if len(report.Skipped) != 2 || report.Skipped[0].Line != 2 || report.Skipped[1].Line != 3 || report.Matched != 3 {
+			t.Errorf("[%s] wrong report: %+v", name, report)
+		}
+		summary := new(bytes.Buffer)
+		report.WriteSummary(summary)
+		if !strings.Contains(summary.String(), "skipped: 2") || !strings.Contains(summary.String(), "line 3:") {
+			t.Errorf("[%s] wrong summary: %s", name, summary)
+		}
+
+		_, err = search(ioutil.Discard, false)
+		lerr := &LineError{}
+		if !errors.As(err, &lerr) || lerr.Line != 2 {
+			t.Errorf("[%s] expected error on line 2, got %v", name, err)
+		}
+	}
+}
+
+func TestSearchMissingFields(t *testing.T) {
+	withDataset(t, strings.Join([]string{
+		`{"browsers":["Android","MSIE 6"],"name":"A"}`,
+		`{"browsers":["Android","MSIE 6"],"name":"B","email":5}`,
+		`{"browsers":"Android MSIE","name":"C","email":"c@x"}`,
+		`{"browsers":["MSIE 7","Android 4"],"email":"d@x","name":null}`,
+	}, "\n"))
+
+	expected := "found users:\n[0] A <>\n[3]  <d [at] x>\n\nTotal unique browsers 4\n"
+	for name, search := range lineSearches {
+		out := new(bytes.Buffer)
+		report, err := search(out, true)
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", name, err)
+			continue
+		}
+		if out.String() != expected {
+			t.Errorf("[%s] results not match\nGot:\n%v\nExpected:\n%v", name, out.String(), expected)
+		}
+		if len(report.Skipped) != 2 || report.Skipped[0].Line != 2 || report.Skipped[1].Line != 3 || report.Matched != 2 {
+			t.Errorf("[%s] wrong report: %+v", name, report)
+		}
+
+		_, err = search(ioutil.Discard, false)
+		lerr := &LineError{}
+		if !errors.As(err, &lerr) || lerr.Line != 2 {
+			t.Errorf("[%s] expected error on line 2, got %v", name, err)
+		}
+	}
+}
+
+func TestSearchMissingFile(t *testing.T) {
+	prev := filePath
+	filePath = filepath.Join(t.TempDir(), "missing.txt")
+	defer func() { filePath = prev }()
+	if err := FastSearch(ioutil.Discard); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+	if err := SlowSearch(ioutil.Discard); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
@@ -1,3 +1,4 @@
 #!/bin/bash
 
-go run fast.go common.go
+# пакет целиком, а не список файлов: иначе go run не учитывает build tags
+go run . "$@"
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// UserBytes is a user line decoded by ScanUser. Slices point into the line
+// itself (or into an internal buffer for strings with escapes) and are only
+// valid until the line buffer or UserBytes is reused.
+type UserBytes struct {
+	Browsers [][]byte
+	Name     []byte
+	Email    []byte
+
+	unescaped []byte
+}
+
+// ScanUser extracts browsers, name and email from a JSON user object
+// without allocating, other fields are skipped.
+func ScanUser(line []byte, u *UserBytes) error {
+	u.Browsers = u.Browsers[:0]
+	u.Name, u.Email = nil, nil
+	u.unescaped = u.unescaped[:0]
+
+	s := &byteScanner{data: line}
+	if !s.consume('{') {
+		return s.fail("expected {")
+	}
+	if s.consume('}') {
+		return s.end()
+	}
+	for {
+		key, err := s.rawString()
+		if err != nil {
+			return err
+		}
+		if !s.consume(':') {
+			return s.fail("expected :")
+		}
+		switch string(key) {
+		case "browsers":
+			if s.literal("null") {
+				break
+			}
+			if !s.consume('[') {
+				return s.fail("expected [")
+			}
+			if s.consume(']') {
+				break
+			}
+			for {
+				browser, err := s.str(u)
+				if err != nil {
+					return err
+				}
+				u.Browsers = append(u.Browsers, browser)
+				if s.consume(']') {
+					break
+				}
+				if !s.consume(',') {
+					return s.fail("expected , or ]")
+				}
+			}
+		case "name":
+			if u.Name, err = s.nullableStr(u); err != nil {
+				return err
+			}
+		case "email":
+			if u.Email, err = s.nullableStr(u); err != nil {
+				return err
+			}
+		default:
+			if err := s.skipValue(); err != nil {
+				return err
+			}
+		}
+		if s.consume('}') {
+			return s.end()
+		}
+		if !s.consume(',') {
+			return s.fail("expected , or }")
+		}
+	}
+}
+
+type byteScanner struct {
+	data []byte
+	pos  int
+}
+
+func (s *byteScanner) fail(msg string) error {
+	return fmt.Errorf("parse error at offset %d: %s", s.pos, msg)
+}
+
+func (s *byteScanner) skipSpace() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\r', '\n':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (s *byteScanner) consume(c byte) bool {
+	s.skipSpace()
+	if s.pos < len(s.data) && s.data[s.pos] == c {
+		s.pos++
+		return true
+	}
+	return false
+}
+
+func (s *byteScanner) literal(lit string) bool {
+	s.skipSpace()
+	if bytes.HasPrefix(s.data[s.pos:], []byte(lit)) {
+		s.pos += len(lit)
+		return true
+	}
+	return false
+}
+
+func (s *byteScanner) end() error {
+	s.skipSpace()
+	if s.pos != len(s.data) {
+		return s.fail("data after object")
+	}
+	return nil
+}
+
+// rawString returns the string contents without unescaping.
+func (s *byteScanner) rawString() ([]byte, error) {
+	if !s.consume('"') {
+		return nil, s.fail("expected string")
+	}
+	start := s.pos
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case '"':
+			s.pos++
+			return s.data[start : s.pos-1], nil
+		case '\\':
+			s.pos++
+		}
+		s.pos++
+	}
+	return nil, s.fail("unterminated string")
+}
+
+// str returns a string value, unescaping into u.unescaped if needed.
+func (s *byteScanner) str(u *UserBytes) ([]byte, error) {
+	raw, err := s.rawString()
+	if err != nil || bytes.IndexByte(raw, '\\') < 0 {
+		return raw, err
+	}
+	start := len(u.unescaped)
+	u.unescaped, err = appendUnescaped(u.unescaped, raw)
+	if err != nil {
+		return nil, s.fail(err.Error())
+	}
+	return u.unescaped[start:len(u.unescaped):len(u.unescaped)], nil
+}
+
+func (s *byteScanner) nullableStr(u *UserBytes) ([]byte, error) {
+	if s.literal("null") {
+		return nil, nil
+	}
+	return s.str(u)
+}
+
+func (s *byteScanner) skipValue() error {
+	s.skipSpace()
+	if s.pos >= len(s.data) {
+		return s.fail("unexpected end")
+	}
+	switch c := s.data[s.pos]; c {
+	case '"':
+		_, err := s.rawString()
+		return err
+	case '{', '[':
+		closing := byte('}')
+		if c == '[' {
+			closing = ']'
+		}
+		s.pos++
+		if s.consume(closing) {
+			return nil
+		}
+		for {
+			if c == '{' {
+				if _, err := s.rawString(); err != nil {
+					return err
+				}
+				if !s.consume(':') {
+					return s.fail("expected :")
+				}
+			}
+			if err := s.skipValue(); err != nil {
+				return err
+			}
+			if s.consume(closing) {
+				return nil
+			}
+			if !s.consume(',') {
+				return s.fail("expected ,")
+			}
+		}
+	case 't':
+		return s.expectLiteral("true")
+	case 'f':
+		return s.expectLiteral("false")
+	case 'n':
+		return s.expectLiteral("null")
+	default:
+		return s.number()
+	}
+}
+
+func (s *byteScanner) expectLiteral(lit string) error {
+	if !s.literal(lit) {
+		return s.fail("expected " + lit)
+	}
+	return nil
+}
+
+// number skips a JSON number: -?(0|[1-9]d*)(.d+)?([eE][+-]?d+)?
+func (s *byteScanner) number() error {
+	digits := func() int {
+		start := s.pos
+		for s.pos < len(s.data) && s.data[s.pos] >= '0' && s.data[s.pos] <= '9' {
+			s.pos++
+		}
+		return s.pos - start
+	}
+	next := func(chars string) bool {
+		if s.pos < len(s.data) && bytes.IndexByte([]byte(chars), s.data[s.pos]) >= 0 {
+			s.pos++
+			return true
+		}
+		return false
+	}
+	next("-")
+	if next("0") {
+		if s.pos < len(s.data) && s.data[s.pos] >= '0' && s.data[s.pos] <= '9' {
+			return s.fail("leading zero in number")
+		}
+	} else if digits() == 0 {
+		return s.fail("expected value")
+	}
+	if next(".") && digits() == 0 {
+		return s.fail("expected digits after .")
+	}
+	if next("eE") {
+		next("+-")
+		if digits() == 0 {
+			return s.fail("expected exponent")
+		}
+	}
+	return nil
+}
+
+func appendUnescaped(dst, raw []byte) ([]byte, error) {
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		if c != '\\' {
+			dst = append(dst, c)
+			continue
+		}
+		i++
+		if i >= len(raw) {
+			return dst, fmt.Errorf("bad escape")
+		}
+		switch raw[i] {
+		case '"', '\\', '/':
+			dst = append(dst, raw[i])
+		case 'b':
+			dst = append(dst, '\b')
+		case 'f':
+			dst = append(dst, '\f')
+		case 'n':
+			dst = append(dst, '\n')
+		case 'r':
+			dst = append(dst, '\r')
+		case 't':
+			dst = append(dst, '\t')
+		case 'u':
+			r, err := parseHex4(raw[i+1:])
+			if err != nil {
+				return dst, err
+			}
+			i += 4
+			// пара суррогатов \uD83D\uDE00 - один символ, одиночный суррогат
+			// заменяем на U+FFFD, как encoding/json
+			if utf16.IsSurrogate(r) {
+				r2 := unicode.ReplacementChar
+				if i+6 < len(raw) && raw[i+1] == '\\' && raw[i+2] == 'u' {
+					if low, err := parseHex4(raw[i+3:]); err == nil {
+						if r2 = utf16.DecodeRune(r, low); r2 != unicode.ReplacementChar {
+							i += 6
+						}
+					}
+				}
+				r = r2
+			}
+			dst = utf8.AppendRune(dst, r)
+		default:
+			return dst, fmt.Errorf("bad escape \\%c", raw[i])
+		}
+	}
+	return dst, nil
+}
+
+func parseHex4(b []byte) (rune, error) {
+	if len(b) < 4 {
+		return 0, fmt.Errorf("bad unicode escape")
+	}
+	r, err := strconv.ParseUint(string(b[:4]), 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad unicode escape")
+	}
+	return rune(r), nil
+}
+
+// scanFields are the fields ScanUser knows about.
+const scanFields = fieldBrowsers | fieldName | fieldEmail
+
+// matchBytes is match for UserBytes, the query may only use scanFields.
+func (q *Query) matchBytes(u *UserBytes, leaves []bool, seen map[string]bool) bool {
+	for _, p := range q.leaves {
+		leaves[p.index] = false
+		switch p.field {
+		case fieldName:
+			leaves[p.index] = p.matchBytes(u.Name)
+		case fieldEmail:
+			leaves[p.index] = p.matchBytes(u.Email)
+		case fieldBrowsers:
+			for _, browser := range u.Browsers {
+				if !p.matchBytes(browser) {
+					continue
+				}
+				leaves[p.index] = true
+				if seen == nil {
+					break
+				}
+				if !seen[string(browser)] {
+					seen[string(browser)] = true
+				}
+			}
+		}
+	}
+	return q.root.eval(leaves)
+}
+
+func (p *predicate) matchBytes(b []byte) bool {
+	switch {
+	case p.re != nil:
+		return p.re.Match(b)
+	case p.op == '=':
+		return string(b) == p.value
+	default:
+		return bytes.Contains(b, p.bvalue)
+	}
+}
+
+// ScanSearch is Search on top of ScanUser: it prints the same output
+// without allocating per line. The query may only use browsers, name
+// and email, and the output always uses the default format.
+func ScanSearch(out io.Writer, r io.Reader, opts SearchOptions) (*SearchReport, error) {
+	q := opts.Query
+	if q.fields&^scanFields != 0 {
+		return nil, fmt.Errorf("ScanSearch: query %q uses fields other than browsers, name and email", q)
+	}
+	if opts.Format != nil {
+		return nil, fmt.Errorf("ScanSearch: custom formatters are not supported")
+	}
+	reader, release, err := NewDatasetReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	report := &SearchReport{}
+	leaves := make([]bool, len(q.leaves))
+	seenBrowsers := make(map[string]bool)
+	user := &UserBytes{}
+	buf := make([]byte, 0, 256)
+	var scratch []byte
+	io.WriteString(out, "found users:\n")
+	for i := 0; ; i++ {
+		line, err := readLine(reader, &scratch)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Lines++
+		if err := ScanUser(line, user); err != nil {
+			if lerr := report.skip(i, err, opts.Tolerant); lerr != nil {
+				return report, lerr
+			}
+			continue
+		}
+		if !q.matchBytes(user, leaves, seenBrowsers) {
+			continue
+		}
+		report.Matched++
+		buf = appendDefaultFormat(buf[:0], i, user.Name, user.Email)
+		out.Write(buf)
+	}
+	buf = append(buf[:0], "\nTotal unique browsers "...)
+	buf = strconv.AppendInt(buf, int64(len(seenBrowsers)), 10)
+	out.Write(append(buf, '\n'))
+	return report, nil
+}
+
+// appendDefaultFormat is DefaultFormat appending to dst.
+func appendDefaultFormat(dst []byte, index int, name, email []byte) []byte {
+	dst = append(dst, '[')
+	dst = strconv.AppendInt(dst, int64(index), 10)
+	dst = append(dst, "] "...)
+	dst = append(dst, name...)
+	dst = append(dst, " <"...)
+	for _, c := range email {
+		if c == '@' {
+			dst = append(dst, " [at] "...)
+			continue
+		}
+		dst = append(dst, c)
+	}
+	return append(dst, ">\n"...)
+}
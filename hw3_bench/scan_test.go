@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestScanUser(t *testing.T) {
+	line := []byte(`{"browsers":["a\"b", "MSIE"], "phone":null, "nested":{"x":[1,{"y":"}"}],"z":true},` +
+		` "name":"Jörg \/ Doe","email":"jd@muxo.edu","age":-1.5e3}`)
+	u := &UserBytes{}
+	if err := ScanUser(line, u); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(u.Browsers) != 2 || string(u.Browsers[0]) != `a"b` || string(u.Browsers[1]) != "MSIE" {
+		t.Errorf("wrong browsers: %q", u.Browsers)
+	}
+	if string(u.Name) != "Jörg / Doe" || string(u.Email) != "jd@muxo.edu" {
+		t.Errorf("wrong name or email: %q %q", u.Name, u.Email)
+	}
+
+	for _, bad := range []string{``, `[]`, `{"name":"x"`, `{"name" "x"}`, `{"browsers":[1]}`, `{"name":"x"} x`, `{"a":}`, `{"name":"\q"}`,
+		`{"a":nul}`, `{"a":truex}`, `{"a":abc}`, `{"a":01}`, `{"a":1.}`, `{"a":-}`, `{"a":1e}`, `{"a":undefined}`} {
+		if err := ScanUser([]byte(bad), u); err == nil {
+			t.Errorf("[%s] expected error, got nil", bad)
+		}
+	}
+}
+
+func TestScanUserSurrogates(t *testing.T) {
+	u := &UserBytes{}
+	for _, name := range []string{`\uD83D\uDE00`, `a\uD83Db`, `\uDE00\uD83D`, `\uD83D\u0041`, `\uD83D\n`, `\uD83D`} {
+		line := `{"name":"` + name + `"}`
+		if err := ScanUser([]byte(line), u); err != nil {
+			t.Errorf("[%s] unexpected error: %s", name, err)
+			continue
+		}
+		var want struct{ Name string }
+		if err := json.Unmarshal([]byte(line), &want); err != nil {
+			t.Fatal(err)
+		}
+		if string(u.Name) != want.Name {
+			t.Errorf("[%s] got %q, encoding/json gives %q", name, u.Name, want.Name)
+		}
+	}
+}
+
+func TestScanUserAllocs(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	u := &UserBytes{}
+	allocs := testing.AllocsPerRun(10, func() {
+		for _, line := range lines {
+			if err := ScanUser(line, u); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("ScanUser allocates: %v allocs per %d lines", allocs, len(lines))
+	}
+}
+
+func TestScanSearch(t *testing.T) {
+	fastOut := new(bytes.Buffer)
+	FastSearch(fastOut)
+	scanOut := new(bytes.Buffer)
+	ScanSearchFile(scanOut, filePath, SearchOptions{Query: defaultQuery})
+	if scanOut.String() != fastOut.String() {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", scanOut.String(), fastOut.String())
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		ScanSearchFile(ioutil.Discard, filePath, SearchOptions{Query: defaultQuery})
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mailru/easyjson/jlexer"
+)
+
+// memDataset is a dataset loaded into memory. Browsers, companies,
+// countries and jobs repeat a lot, so they are interned and users keep
+// only their ids.
+type memDataset struct {
+	strs  []string
+	users []memUser
+	// browsers holds browser ids of all users back to back
+	browsers []uint32
+
+	size    int64
+	modTime time.Time
+}
+
+type memUser struct {
+	line                  int
+	name, email, phone    string
+	company, country, job uint32
+	// browsers[from:to] of the dataset
+	from, to uint32
+}
+
+// loadDataset reads a possibly compressed dataset, skipping malformed
+// lines.
+func loadDataset(path string) (*memDataset, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	reader, release, err := NewDatasetReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ds := &memDataset{size: info.Size(), modTime: info.ModTime()}
+	ids := make(map[string]uint32)
+	intern := func(s string) uint32 {
+		id, ok := ids[s]
+		if !ok {
+			id = uint32(len(ds.strs))
+			ids[s] = id
+			ds.strs = append(ds.strs, s)
+		}
+		return id
+	}
+	all := fieldBrowsers | fieldCompany | fieldCountry | fieldEmail | fieldJob | fieldName | fieldPhone
+	user := &User{}
+	var scratch []byte
+	for i := 0; ; i++ {
+		line, err := readLine(reader, &scratch)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		lexer := jlexer.Lexer{Data: line}
+		decodeUserFields(&lexer, user, all)
+		if lexer.Error() != nil {
+			continue
+		}
+		mu := memUser{
+			line:    i,
+			name:    user.Name,
+			email:   user.Email,
+			phone:   user.Phone,
+			company: intern(user.Company),
+			country: intern(user.Country),
+			job:     intern(user.Job),
+			from:    uint32(len(ds.browsers)),
+		}
+		for _, browser := range user.Browsers {
+			ds.browsers = append(ds.browsers, intern(browser))
+		}
+		mu.to = uint32(len(ds.browsers))
+		ds.users = append(ds.users, mu)
+	}
+	return ds, nil
+}
+
+// fill makes u a view of the i-th user, reusing u.Browsers.
+func (ds *memDataset) fill(u *User, i int) {
+	mu := &ds.users[i]
+	browsers := u.Browsers[:0]
+	for _, id := range ds.browsers[mu.from:mu.to] {
+		browsers = append(browsers, ds.strs[id])
+	}
+	*u = User{
+		Browsers: browsers,
+		Company:  ds.strs[mu.company],
+		Country:  ds.strs[mu.country],
+		Email:    mu.email,
+		Job:      ds.strs[mu.job],
+		Name:     mu.name,
+		Phone:    mu.phone,
+	}
+}
+
+// SearchServer serves GET /search over a dataset kept in memory. The
+// dataset is reloaded when its size or modification time changes.
+type SearchServer struct {
+	path string
+	// Redact is applied to found users, DefaultRedactor unless changed
+	// before serving
+	Redact *Redactor
+
+	mu sync.RWMutex
+	ds *memDataset
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 1000
+)
+
+// NewSearchServer loads the dataset at path and checks it for changes
+// every pollInterval, zero disables reloading. Close stops the polling.
+func NewSearchServer(path string, pollInterval time.Duration) (*SearchServer, error) {
+	ds, err := loadDataset(path)
+	if err != nil {
+		return nil, err
+	}
+	// копия, чтобы правка s.Redact не меняла DefaultRedactor
+	redact := DefaultRedactor
+	s := &SearchServer{path: path, Redact: &redact, ds: ds, stop: make(chan struct{}), done: make(chan struct{})}
+	if pollInterval > 0 {
+		go s.watch(pollInterval)
+	} else {
+		close(s.done)
+	}
+	return s, nil
+}
+
+// Close stops watching the dataset.
+func (s *SearchServer) Close() {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+}
+
+func (s *SearchServer) watch(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if _, err := s.Reload(false); err != nil {
+				log.Println("reload", s.path, err)
+			}
+		}
+	}
+}
+
+// Reload loads the dataset again if it changed or force is set and
+// reports whether it did. On error the old dataset is kept.
+func (s *SearchServer) Reload(force bool) (bool, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	old := s.ds
+	s.mu.RUnlock()
+	if !force && info.Size() == old.size && info.ModTime().Equal(old.modTime) {
+		return false, nil
+	}
+	ds, err := loadDataset(s.path)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	s.ds = ds
+	s.mu.Unlock()
+	return true, nil
+}
+
+// SearchMatch is a found user in a search response.
+type SearchMatch struct {
+	Index int    `json:"index"`
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+	Phone string `json:"phone,omitempty"`
+}
+
+// SearchResponse is the body of a successful /search response.
+type SearchResponse struct {
+	Query  string `json:"query"`
+	Total  int    `json:"total"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+	// UniqueBrowsers counts distinct browsers matched by the query's
+	// browsers predicates over the whole dataset, like FastSearch
+	UniqueBrowsers int           `json:"unique_browsers"`
+	Users          []SearchMatch `json:"users"`
+}
+
+func (s *SearchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/search" {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+		return
+	}
+	params := r.URL.Query()
+	q := defaultQuery
+	if src := params.Get("q"); src != "" {
+		var err error
+		if q, err = CompileQuery(src); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.Redact.CheckQuery(q); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	limit, err := intParam(params.Get("limit"), defaultSearchLimit)
+	if err != nil || limit < 0 {
+		writeJSONError(w, http.StatusBadRequest, "bad limit")
+		return
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	offset, err := intParam(params.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeJSONError(w, http.StatusBadRequest, "bad offset")
+		return
+	}
+
+	s.mu.RLock()
+	ds := s.ds
+	s.mu.RUnlock()
+	res := ds.search(q, offset, limit, s.Redact)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (ds *memDataset) search(q *Query, offset, limit int, redact *Redactor) *SearchResponse {
+	res := &SearchResponse{Query: q.String(), Offset: offset, Limit: limit, Users: []SearchMatch{}}
+	leaves := make([]bool, len(q.leaves))
+	seen := make(map[string]bool)
+	user := &User{}
+	for i := range ds.users {
+		ds.fill(user, i)
+		if !q.match(user, leaves, seen) {
+			continue
+		}
+		if res.Total >= offset && len(res.Users) < limit {
+			u := redact.Redact(user)
+			res.Users = append(res.Users, SearchMatch{
+				Index: ds.users[i].line,
+				Name:  u.Name,
+				Email: u.Email,
+				Phone: u.Phone,
+			})
+		}
+		res.Total++
+	}
+	res.UniqueBrowsers = len(seen)
+	return res
+}
+
+func intParam(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// serveSearch runs the search service until it fails.
+func serveSearch(addr, path string, redact *Redactor) error {
+	srv, err := NewSearchServer(path, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	srv.Redact = redact
+	defer srv.Close()
+	fmt.Fprintln(os.Stderr, "serving", path, "on", addr)
+	return http.ListenAndServe(addr, srv)
+}
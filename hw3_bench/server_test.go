@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getSearch(t *testing.T, ts *httptest.Server, params url.Values) (int, *SearchResponse, string) {
+	resp, err := http.Get(ts.URL + "/search?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := &SearchResponse{}
+	if resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(body, res); err != nil {
+			t.Fatalf("bad response %s: %s", body, err)
+		}
+	}
+	return resp.StatusCode, res, string(body)
+}
+
+func TestSearchServer(t *testing.T) {
+	srv, err := NewSearchServer(filePath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	// ответ по умолчанию должен совпадать с FastSearch
+	status, res, body := getSearch(t, ts, url.Values{"limit": {"1000"}})
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", status, body)
+	}
+	expected := new(strings.Builder)
+	if err := FastSearch(expected); err != nil {
+		t.Fatal(err)
+	}
+	got := new(strings.Builder)
+	got.WriteString("found users:\n")
+	for _, u := range res.Users {
+		DefaultFormat(got, u.Index, &User{Name: u.Name, Email: strings.Replace(u.Email, " [at] ", "@", 1)})
+	}
+	fmt.Fprintln(got)
+	fmt.Fprintln(got, "Total unique browsers", res.UniqueBrowsers)
+	if got.String() != expected.String() {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", got, expected)
+	}
+	if res.Total != len(res.Users) {
+		t.Errorf("total %d, users %d", res.Total, len(res.Users))
+	}
+
+	_, page, _ := getSearch(t, ts, url.Values{"limit": {"2"}, "offset": {"3"}})
+	if page.Total != res.Total || len(page.Users) != 2 || page.Users[0] != res.Users[3] || page.Users[1] != res.Users[4] {
+		t.Errorf("wrong page: %+v", page)
+	}
+
+	_, filtered, _ := getSearch(t, ts, url.Values{"q": {"country=Peru AND browsers~Chrome"}})
+	for _, u := range filtered.Users {
+		if u.Index < 0 || strings.Contains(u.Email, "@") {
+			t.Errorf("bad match %+v", u)
+		}
+	}
+	if filtered.Total == 0 || filtered.Query != "country=Peru AND browsers~Chrome" {
+		t.Errorf("wrong filtered response: %+v", filtered)
+	}
+
+	// по скрытым полям искать нельзя, иначе их можно подобрать
+	srv.Redact.Name = RedactMask
+	if DefaultRedactor.Name != RedactKeep {
+		t.Error("changing srv.Redact changed DefaultRedactor")
+	}
+	for _, q := range []string{"email~/^a/", "phone~12", "browsers~MSIE OR name~/^A/", "NOT name=x"} {
+		if status, _, body := getSearch(t, ts, url.Values{"q": {q}}); status != http.StatusBadRequest || !strings.Contains(body, "redacted") {
+			t.Errorf("%s: expected 400 for a redacted field, got %d %s", q, status, body)
+		}
+	}
+	srv.Redact.Name = RedactKeep
+	if status, _, body := getSearch(t, ts, url.Values{"q": {"name~/^A/"}}); status != http.StatusOK {
+		t.Errorf("query on a kept field: status %d %s", status, body)
+	}
+
+	for _, params := range []url.Values{{"q": {"(("}}, {"limit": {"x"}}, {"offset": {"-1"}}} {
+		if status, _, body := getSearch(t, ts, params); status != http.StatusBadRequest || !strings.Contains(body, `"error"`) {
+			t.Errorf("%v: expected 400 with error, got %d %s", params, status, body)
+		}
+	}
+	resp, err := http.Post(ts.URL+"/search", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST: expected 405, got %d", resp.StatusCode)
+	}
+}
+
+func TestSearchServerReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.txt")
+	write := func(data string, mtime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-time.Hour)
+	write(`{"browsers":["Android","MSIE"],"name":"A","email":"a@x"}`, start)
+
+	srv, err := NewSearchServer(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	if _, res, _ := getSearch(t, ts, nil); res.Total != 1 {
+		t.Fatalf("expected 1 match, got %+v", res)
+	}
+	write("{\"browsers\":[\"Android\",\"MSIE\"],\"name\":\"A\",\"email\":\"a@x\"}\nbroken\n{\"browsers\":[\"MSIE 9\",\"Android 4\"],\"name\":\"B\",\"email\":\"b@x\"}", start.Add(time.Minute))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, res, _ := getSearch(t, ts, nil)
+		if res.Total == 2 {
+			if res.Users[1].Index != 2 || res.UniqueBrowsers != 4 {
+				t.Errorf("wrong response after reload: %+v", res)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dataset was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if reloaded, err := srv.Reload(false); err != nil || reloaded {
+		t.Errorf("unchanged file reloaded: %v %v", reloaded, err)
+	}
+	os.Remove(path)
+	if _, err := srv.Reload(true); err == nil {
+		t.Error("expected error for missing file")
+	}
+	if _, res, _ := getSearch(t, ts, nil); res.Total != 2 {
+		t.Errorf("old dataset lost after failed reload: %+v", res)
+	}
+}
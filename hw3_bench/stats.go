@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mailru/easyjson/jlexer"
+)
+
+// BrowserStats counts browsers entries of a dataset by parsed user agent.
+// Every entry is counted, so a user with three browsers adds three.
+type BrowserStats struct {
+	Total      int
+	Families   map[string]int
+	OS         map[string]int
+	Devices    map[string]int
+	IEVersions map[string]int
+
+	// parsed caches ParseUserAgent, datasets repeat the same strings a lot
+	parsed map[string]UserAgent
+}
+
+// NewBrowserStats returns empty stats.
+func NewBrowserStats() *BrowserStats {
+	return &BrowserStats{
+		Families:   make(map[string]int),
+		OS:         make(map[string]int),
+		Devices:    make(map[string]int),
+		IEVersions: make(map[string]int),
+		parsed:     make(map[string]UserAgent),
+	}
+}
+
+// Add counts a single browsers entry.
+func (s *BrowserStats) Add(browser string) {
+	ua, ok := s.parsed[browser]
+	if !ok {
+		ua = ParseUserAgent(browser)
+		s.parsed[browser] = ua
+	}
+	s.Total++
+	s.Families[ua.Family]++
+	s.OS[ua.OS]++
+	s.Devices[ua.Device]++
+	if ua.Family == "IE" || ua.Family == "IE Mobile" {
+		s.IEVersions[ua.Family+" "+ua.Major()]++
+	}
+}
+
+// CollectBrowserStats reads a possibly compressed dataset and counts all
+// browsers. Malformed lines stop it unless tolerant is set.
+func CollectBrowserStats(r io.Reader, tolerant bool) (*BrowserStats, *SearchReport, error) {
+	reader, release, err := NewDatasetReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	stats := NewBrowserStats()
+	report := &SearchReport{}
+	user := &User{}
+	var scratch []byte
+	for i := 0; ; i++ {
+		line, err := readLine(reader, &scratch)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, report, err
+		}
+		report.Lines++
+		lexer := jlexer.Lexer{Data: line}
+		decodeUserFields(&lexer, user, fieldBrowsers)
+		if err := lexer.Error(); err != nil {
+			if lerr := report.skip(i, err, tolerant); lerr != nil {
+				return stats, report, lerr
+			}
+			continue
+		}
+		for _, browser := range user.Browsers {
+			stats.Add(browser)
+		}
+	}
+	return stats, report, nil
+}
+
+// HistogramEntry is a single bar of a histogram.
+type HistogramEntry struct {
+	Key   string  `json:"key"`
+	Count int     `json:"count"`
+	Share float64 `json:"share"`
+}
+
+// Histogram returns counts sorted by count descending, then by key. Only
+// the top entries are kept if top > 0, the rest are summed up as "(other)".
+func Histogram(counts map[string]int, top int) []HistogramEntry {
+	total := 0
+	res := make([]HistogramEntry, 0, len(counts))
+	for key, count := range counts {
+		res = append(res, HistogramEntry{Key: key, Count: count})
+		total += count
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Key < res[j].Key
+	})
+	if top > 0 && len(res) > top {
+		rest := HistogramEntry{Key: "(other)"}
+		for _, e := range res[top:] {
+			rest.Count += e.Count
+		}
+		res = append(res[:top], rest)
+	}
+	for i := range res {
+		res[i].Share = float64(res[i].Count) / float64(total)
+	}
+	return res
+}
+
+type histogramSection struct {
+	Name    string
+	Entries []HistogramEntry
+}
+
+// histograms returns the report sections in print order.
+func (s *BrowserStats) histograms(top int) []histogramSection {
+	return []histogramSection{
+		{"families", Histogram(s.Families, top)},
+		{"os", Histogram(s.OS, top)},
+		{"devices", Histogram(s.Devices, top)},
+		{"ie_versions", Histogram(s.IEVersions, top)},
+	}
+}
+
+// WriteReport prints histograms of the top entries in "text", "csv" or
+// "json" format.
+func (s *BrowserStats) WriteReport(w io.Writer, format string, top int) error {
+	switch format {
+	case "text":
+		return s.writeText(w, top)
+	case "csv":
+		return s.writeCSV(w, top)
+	case "json":
+		return s.writeJSON(w, top)
+	}
+	return fmt.Errorf("unknown report format %q, want text, csv or json", format)
+}
+
+const histogramWidth = 40
+
+func (s *BrowserStats) writeText(w io.Writer, top int) error {
+	fmt.Fprintf(w, "browsers: %d\n", s.Total)
+	for _, h := range s.histograms(top) {
+		fmt.Fprintf(w, "\n%s:\n", h.Name)
+		keyWidth := 0
+		for _, e := range h.Entries {
+			if len(e.Key) > keyWidth {
+				keyWidth = len(e.Key)
+			}
+		}
+		for _, e := range h.Entries {
+			bar := strings.Repeat("#", int(e.Share*histogramWidth+0.5))
+			if _, err := fmt.Fprintf(w, "  %-*s %6d %5.1f%% %s\n", keyWidth, e.Key, e.Count, e.Share*100, bar); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *BrowserStats) writeCSV(w io.Writer, top int) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"section", "key", "count", "share"})
+	for _, h := range s.histograms(top) {
+		for _, e := range h.Entries {
+			cw.Write([]string{h.Name, e.Key, strconv.Itoa(e.Count), strconv.FormatFloat(e.Share, 'f', 4, 64)})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (s *BrowserStats) writeJSON(w io.Writer, top int) error {
+	res := map[string]interface{}{"total": s.Total}
+	for _, h := range s.histograms(top) {
+		res[h.Name] = h.Entries
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
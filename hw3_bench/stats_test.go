@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	got := Histogram(map[string]int{"a": 1, "b": 5, "c": 2, "d": 2}, 2)
+	expected := []HistogramEntry{{"b", 5, 0.5}, {"c", 2, 0.2}, {"(other)", 3, 0.3}}
+	if len(got) != len(expected) {
+		t.Fatalf("wrong histogram %+v", got)
+	}
+	for i := range expected {
+		if got[i].Key != expected[i].Key || got[i].Count != expected[i].Count || math.Abs(got[i].Share-expected[i].Share) > 1e-9 {
+			t.Errorf("entry %d: got %+v, expected %+v", i, got[i], expected[i])
+		}
+	}
+}
+
+func TestBrowserStatsReport(t *testing.T) {
+	data := strings.Join([]string{
+		`{"browsers":["Mozilla/5.0 (compatible; MSIE 10.0; Windows NT 6.1; WOW64; Trident/6.0)","Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 5.1)"],"name":"A"}`,
+		`{"browsers":["Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:35.0) Gecko/20100101 Firefox/35.0"],"name":"B"}`,
+		`{"browsers":["Mozilla/5.0 (Windows NT 5.1; rv:31.0) Gecko/20100101 Firefox/31.0"]}`,
+	}, "\n")
+	stats, report, err := CollectBrowserStats(strings.NewReader(data), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 4 || report.Lines != 3 {
+		t.Fatalf("wrong totals: %d browsers, %d lines", stats.Total, report.Lines)
+	}
+	if stats.Families["Firefox"] != 2 || stats.Families["IE"] != 2 || stats.OS["Windows"] != 3 || stats.IEVersions["IE 7"] != 1 {
+		t.Errorf("wrong stats: %+v", stats)
+	}
+
+	text := new(bytes.Buffer)
+	if err := stats.WriteReport(text, "text", 0); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "  Firefox      2  50.0% ####################\n") {
+		t.Errorf("unexpected text report:\n%s", text)
+	}
+
+	csvOut := new(bytes.Buffer)
+	if err := stats.WriteReport(csvOut, "csv", 1); err != nil {
+		t.Fatal(err)
+	}
+	expectedCSV := "section,key,count,share\n" +
+		"families,Firefox,2,0.5000\nfamilies,(other),2,0.5000\n" +
+		"os,Windows,3,0.7500\nos,(other),1,0.2500\n" +
+		"devices,desktop,4,1.0000\n" +
+		"ie_versions,IE 10,1,0.5000\nie_versions,(other),1,0.5000\n"
+	if csvOut.String() != expectedCSV {
+		t.Errorf("unexpected csv report\nGot:\n%s\nExpected:\n%s", csvOut, expectedCSV)
+	}
+
+	jsonOut := new(bytes.Buffer)
+	if err := stats.WriteReport(jsonOut, "json", 0); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Total    int              `json:"total"`
+		Families []HistogramEntry `json:"families"`
+	}
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Total != 4 || len(decoded.Families) != 2 || decoded.Families[0].Key != "Firefox" {
+		t.Errorf("unexpected json report: %s", jsonOut)
+	}
+
+	if err := stats.WriteReport(new(bytes.Buffer), "xml", 0); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestBrowserStatsMalformed(t *testing.T) {
+	data := `{"browsers":["w3m/0.5.1"]}` + "\n" + `{"browsers":[` + "\n"
+	if _, _, err := CollectBrowserStats(strings.NewReader(data), false); err == nil {
+		t.Error("expected error in strict mode")
+	}
+	stats, report, err := CollectBrowserStats(strings.NewReader(data), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 1 || len(report.Skipped) != 1 || report.Skipped[0].Line != 2 {
+		t.Errorf("wrong result: %+v %+v", stats, report)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"strings"
+)
+
+// UserAgent is a browsers entry classified by ParseUserAgent.
+type UserAgent struct {
+	// Family is e.g. "IE", "Chrome", "Android Browser", "Bot" or "Other"
+	Family string
+	// Version is the browser version as written in the string, may be empty
+	Version string
+	OS      string
+	// Device is "desktop", "mobile", "tablet", "bot" or "other"
+	Device string
+}
+
+// Major returns the version up to the first dot.
+func (ua UserAgent) Major() string {
+	if i := strings.IndexByte(ua.Version, '.'); i >= 0 {
+		return ua.Version[:i]
+	}
+	return ua.Version
+}
+
+// uaRule detects a family by token, the version follows the token after
+// an optional '/' or space, or follows versionToken if that is present.
+type uaRule struct {
+	family       string
+	token        string
+	versionToken string
+}
+
+// порядок важен: Opera и Edge притворяются Chrome, Chrome притворяется Safari
+var familyRules = []uaRule{
+	{"Edge", "Edge/", ""},
+	{"Opera", "OPR/", ""},
+	{"Opera Mini", "Opera Mini/", ""},
+	{"Opera Mobile", "Opera Mobi", "Version/"},
+	{"Opera", "Opera/", "Version/"},
+	{"Opera", "Opera ", ""},
+	{"IE Mobile", "IEMobile", ""},
+	{"IE", "MSIE", ""},
+	{"IE", "Trident/", "rv:"},
+	{"Samsung Browser", "SamsungBrowser/", ""},
+	{"UC Browser", "UCBrowser/", ""},
+	{"Silk", "Silk/", ""},
+	{"Puffin", "Puffin/", ""},
+	{"Chrome", "CriOS/", ""},
+	{"Chromium", "Chromium/", ""},
+	{"Chrome", "Chrome/", ""},
+	{"SeaMonkey", "SeaMonkey/", ""},
+	{"Firefox", "Firefox/", ""},
+	{"Konqueror", "Konqueror/", ""},
+	{"Epiphany", "Epiphany/", ""},
+	{"Nokia Browser", "NokiaBrowser/", ""},
+	{"Nokia Browser", "BrowserNG/", ""},
+	{"Safari", "Safari/", "Version/"},
+	{"NetFront", "NetFront/", ""},
+	{"UP.Browser", "UP.Browser/", ""},
+	{"Galeon", "Galeon/", ""},
+	{"ELinks", "ELinks", ""},
+	{"Links", "Links", ""},
+	{"Lynx", "Lynx/", ""},
+	{"w3m", "w3m/", ""},
+	{"Wget", "Wget/", ""},
+}
+
+var botTokens = []string{"bot", "crawl", "spider", "teoma", "slurp", "fetch", "validator"}
+
+// osRule maps the first found token to an OS name.
+type osRule struct {
+	os     string
+	tokens []string
+}
+
+var osRules = []osRule{
+	{"Windows Phone", []string{"Windows Phone"}},
+	{"Windows Mobile", []string{"Windows CE", "PPC;"}},
+	{"Windows", []string{"Windows"}},
+	{"iOS", []string{"iPhone", "iPad", "iPod"}},
+	{"Mac OS X", []string{"Macintosh", "Mac OS X"}},
+	{"Android", []string{"Android"}},
+	{"Symbian", []string{"Symbian", "SymbOS", "Series60", "S60;"}},
+	{"BlackBerry", []string{"BlackBerry"}},
+	{"webOS", []string{"webOS", "hpwOS"}},
+	{"Chrome OS", []string{"CrOS"}},
+	{"BSD", []string{"FreeBSD", "OpenBSD", "NetBSD"}},
+	{"SunOS", []string{"SunOS"}},
+	{"Linux", []string{"Linux", "X11"}},
+	{"OS/2", []string{"OS/2"}},
+	{"J2ME", []string{"J2ME", "MIDP"}},
+}
+
+var (
+	tabletTokens = []string{"iPad", "Tablet", "tablet", "Xoom", "Nexus 7", "Nexus 9", "KFTT", "Silk/", "TouchPad", "SM-T", "GT-P"}
+	mobileTokens = []string{"Mobile", "Mobi", "iPhone", "iPod", "Phone", "MIDP", "BlackBerry", "Opera Mini", "IEMobile", "Windows CE", "Symbian", "SymbOS", "Series60", "UP.Browser", "NetFront"}
+)
+
+// ParseUserAgent classifies a browsers entry with a few ordered token
+// rules. It is a heuristic good enough for statistics, not a full parser.
+func ParseUserAgent(s string) UserAgent {
+	ua := UserAgent{Family: "Other", OS: "Other", Device: "other"}
+	for _, rule := range osRules {
+		if containsAny(s, rule.tokens) {
+			ua.OS = rule.os
+			break
+		}
+	}
+
+	lower := strings.ToLower(s)
+	for _, token := range botTokens {
+		if strings.Contains(lower, token) {
+			ua.Family, ua.Device = "Bot", "bot"
+			return ua
+		}
+	}
+
+	for _, rule := range familyRules {
+		pos := strings.Index(s, rule.token)
+		if pos < 0 {
+			continue
+		}
+		ua.Family = rule.family
+		ua.Version = versionAfter(s[pos+len(rule.token):])
+		if rule.versionToken != "" {
+			if vpos := strings.Index(s, rule.versionToken); vpos >= 0 {
+				ua.Version = versionAfter(s[vpos+len(rule.versionToken):])
+			}
+		}
+		break
+	}
+	if ua.Family == "Safari" && ua.OS == "Android" {
+		ua.Family = "Android Browser"
+	}
+
+	switch {
+	case containsAny(s, tabletTokens):
+		ua.Device = "tablet"
+	case containsAny(s, mobileTokens), ua.OS == "Windows Phone", ua.OS == "J2ME":
+		ua.Device = "mobile"
+	case ua.OS == "Android":
+		// Android без Mobile - планшет
+		ua.Device = "tablet"
+	case ua.OS != "Other":
+		ua.Device = "desktop"
+	}
+	return ua
+}
+
+// versionAfter returns the dotted version at the start of s, skipping one
+// leading '/' or space.
+func versionAfter(s string) string {
+	if len(s) > 0 && (s[0] == '/' || s[0] == ' ') {
+		s = s[1:]
+	}
+	end := 0
+	for end < len(s) && (s[end] >= '0' && s[end] <= '9' || s[end] == '.' && end > 0) {
+		end++
+	}
+	return strings.TrimRight(s[:end], ".")
+}
+
+func containsAny(s string, tokens []string) bool {
+	for _, token := range tokens {
+		if strings.Contains(s, token) {
+			return true
+		}
+	}
+	return false
+}
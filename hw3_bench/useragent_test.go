@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestParseUserAgent(t *testing.T) {
+	cases := []struct {
+		ua       string
+		expected UserAgent
+	}{
+		{
+			"Mozilla/4.0 (compatible; MSIE 6.0; Windows CE; IEMobile 6.12; Microsoft ZuneHD 4.3)",
+			UserAgent{"IE Mobile", "6.12", "Windows Mobile", "mobile"},
+		},
+		{
+			"Mozilla/5.0 (compatible; MSIE 10.0; Windows NT 6.1; WOW64; Trident/6.0)",
+			UserAgent{"IE", "10.0", "Windows", "desktop"},
+		},
+		{
+			"Mozilla/5.0 (Windows NT 6.2; ARM; Trident/7.0; Touch; rv:11.0; WPDesktop; NOKIA; Lumia 920) like Geckoo",
+			UserAgent{"IE", "11.0", "Windows", "desktop"},
+		},
+		{
+			"Mozilla/5.0 (Windows Phone 10.0; Android 4.2.1; DEVICE INFO) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/39.0.2171.71 Mobile Safari/537.36 Edge/12.0",
+			UserAgent{"Edge", "12.0", "Windows Phone", "mobile"},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 5.1.1; Nexus 7 Build/LMY47V) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/43.0.2357.78 Safari/537.36 OPR/30.0.1856.93524",
+			UserAgent{"Opera", "30.0.1856.93524", "Android", "tablet"},
+		},
+		{
+			"Opera/9.80 (Android; Opera Mini/7.5.33361/31.1543; U; en) Presto/2.8.119 Version/11.1010",
+			UserAgent{"Opera Mini", "7.5.33361", "Android", "mobile"},
+		},
+		{
+			"Opera/9.80 (X11; Linux i686) Presto/2.12.388 Version/12.16",
+			UserAgent{"Opera", "12.16", "Linux", "desktop"},
+		},
+		{
+			"Mozilla/5.0 (Linux; U; Android 2.0; en-us; Droid Build/ESD20) AppleWebKit/530.17 (KHTML, like Gecko) Version/4.0 Mobile Safari/530.17",
+			UserAgent{"Android Browser", "4.0", "Android", "mobile"},
+		},
+		{
+			"Mozilla/5.0 (iPad; U; CPU OS 3_2 like Mac OS X; en-us) AppleWebKit/531.21.10 (KHTML, like Gecko) Version/4.0.4 Mobile/7B334b Safari/531.21.10",
+			UserAgent{"Safari", "4.0.4", "iOS", "tablet"},
+		},
+		{
+			"Mozilla/5.0 (iPhone; U; CPU iPhone OS 5_1_1 like Mac OS X; da-dk) AppleWebKit/534.46.0 (KHTML, like Gecko) CriOS/19.0.1084.60 Mobile/9B206 Safari/7534.48.3",
+			UserAgent{"Chrome", "19.0.1084.60", "iOS", "mobile"},
+		},
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_7_3) AppleWebKit/534.55.3 (KHTML, like Gecko) Version/5.1.3 Safari/534.53.10",
+			UserAgent{"Safari", "5.1.3", "Mac OS X", "desktop"},
+		},
+		{
+			"Mozilla/5.0 (Windows NT 5.2; rv:10.0.1) Gecko/20100101 Firefox/10.0.1 SeaMonkey/2.7.1",
+			UserAgent{"SeaMonkey", "2.7.1", "Windows", "desktop"},
+		},
+		{
+			"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:35.0) Gecko/20100101 Firefox/35.0",
+			UserAgent{"Firefox", "35.0", "Linux", "desktop"},
+		},
+		{
+			"Mozilla/5.0 (Symbian/3; Series60/5.2 NokiaN8-00/014.002; Profile/MIDP-2.1 Configuration/CLDC-1.1; en-us) AppleWebKit/525 (KHTML, like Gecko) Version/3.0 BrowserNG/7.2.6.4 3gpp-gba",
+			UserAgent{"Nokia Browser", "7.2.6.4", "Symbian", "mobile"},
+		},
+		{
+			"SAMSUNG-SGH-E250/1.0 Profile/MIDP-2.0 Configuration/CLDC-1.1 UP.Browser/6.2.3.3.c.1.101 (GUI) MMP/2.0 (compatible; Googlebot-Mobile/2.1;  http://www.google.com/bot.html)",
+			UserAgent{"Bot", "", "J2ME", "bot"},
+		},
+		{
+			"w3m/0.5.1",
+			UserAgent{"w3m", "0.5.1", "Other", "other"},
+		},
+		{
+			"Adobe Application Manager 2.0",
+			UserAgent{"Other", "", "Other", "other"},
+		},
+	}
+	for _, c := range cases {
+		if got := ParseUserAgent(c.ua); got != c.expected {
+			t.Errorf("ParseUserAgent(%q)\nGot:      %+v\nExpected: %+v", c.ua, got, c.expected)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BreakerState is a state of CircuitBreaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets all requests through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails requests without making them
+	BreakerOpen
+	// BreakerHalfOpen lets a single probe request through, its result
+	// closes or opens the breaker again
+	BreakerHalfOpen
+)
+
+var breakerStateNames = []string{"closed", "open", "half-open"}
+
+func (s BreakerState) String() string {
+	if s >= 0 && int(s) < len(breakerStateNames) {
+		return breakerStateNames[s]
+	}
+	return "BreakerState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// CircuitBreaker opens after Threshold failures of the search system in a
+// row and then fails requests fast with ErrCircuitOpen. After Cooldown it
+// half-opens and lets one request probe the system. Only failures retried
+// by RetryPolicy count, a bad request means the system is up. A nil
+// breaker lets everything through.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	// OnStateChange is called after every transition, outside of the lock
+	OnStateChange func(from, to BreakerState)
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	// now is time.Now if nil, tests move the clock
+	now func() time.Time
+}
+
+// NewCircuitBreaker returns a closed breaker.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// State returns the current state, an open breaker past its cooldown is
+// still reported open until a request comes.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow returns ErrCircuitOpen if a request should not be made.
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	from := b.state
+	switch b.state {
+	case BreakerOpen:
+		if b.clock().Sub(b.openedAt) < b.Cooldown {
+			b.mu.Unlock()
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+	case BreakerHalfOpen:
+		if b.probing {
+			b.mu.Unlock()
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	b.unlock(from)
+	return nil
+}
+
+// Record accounts the result of an allowed request. Requests stopped by
+// ctx say nothing about the search system and are not counted.
+func (b *CircuitBreaker) Record(ctx context.Context, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	from := b.state
+	failed := err != nil && retryable(err)
+	switch {
+	case ctx.Err() != nil:
+		b.probing = false
+	case b.state == BreakerHalfOpen:
+		b.probing = false
+		if failed {
+			b.open()
+		} else {
+			b.state = BreakerClosed
+			b.failures = 0
+		}
+	case !failed:
+		b.failures = 0
+	case b.state == BreakerClosed:
+		b.failures++
+		if b.failures >= b.Threshold {
+			b.open()
+		}
+	}
+	b.unlock(from)
+}
+
+func (b *CircuitBreaker) open() {
+	b.state = BreakerOpen
+	b.openedAt = b.clock()
+	b.failures = 0
+}
+
+func (b *CircuitBreaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+// unlock releases the lock and reports a transition from the from state.
+func (b *CircuitBreaker) unlock(from BreakerState) {
+	to := b.state
+	hook := b.OnStateChange
+	b.mu.Unlock()
+	if hook != nil && from != to {
+		hook(from, to)
+	}
+}
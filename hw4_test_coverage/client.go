@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,6 +19,9 @@ const (
 	orderDesc
 )
 
+// maxLimit is the most users FindUsers returns at once
+const maxLimit = 25
+
 var (
 	errTest = errors.New("testing")
 	client  = &http.Client{Timeout: time.Second}
@@ -37,6 +42,9 @@ type SearchResponse struct {
 
 type SearchErrorResponse struct {
 	Error string
+	// Field is the rejected key of order_field, servers before sort keys
+	// do not send it
+	Field string `json:",omitempty"`
 }
 
 const (
@@ -54,6 +62,21 @@ type SearchRequest struct {
 	OrderField string
 	// -1 по убыванию, 0 как встретилось, 1 по возрастанию
 	OrderBy int
+	// SortKeys сортирует по нескольким полям по очереди, если заданы -
+	// вместо OrderField и OrderBy
+	SortKeys []SortKey
+
+	// фильтры, нулевые значения не фильтруют
+	AgeMin     int
+	AgeMax     int
+	Gender     string // male или female
+	NamePrefix string // начало Name
+}
+
+// SortKey is a field to order by and its OrderBy direction.
+type SortKey struct {
+	Field string
+	Order int
 }
 
 type SearchClient struct {
@@ -61,22 +84,44 @@ type SearchClient struct {
 	AccessToken string
 	// урл внешней системы, куда идти
 	URL string
+	// Client делает запросы, свой Transport подставляется через него.
+	// Если nil - общий клиент с таймаутом в секунду
+	Client *http.Client
+	// Retry повторяет запросы, упавшие по таймауту или с 5xx, nil - без повторов
+	Retry *RetryPolicy
+	// Breaker перестаёт ходить во внешнюю систему, пока она лежит
+	Breaker *CircuitBreaker
 }
 
 // FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользоваталей
 func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+	return srv.FindUsersContext(context.Background(), req)
+}
+
+// FindUsersContext is FindUsers bounded by ctx. Errors of the search
+// system are ErrUnauthorized, ErrBadOrderField or *ServerError. A request
+// cancelled by ctx returns an error wrapping context.Canceled, a timeout
+// of ctx or of the http client returns *TimeoutError. With Breaker set
+// an open breaker returns ErrCircuitOpen without a request.
+func (srv *SearchClient) FindUsersContext(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
 
 	searcherParams := url.Values{}
 
 	if req.Limit < 0 {
 		return nil, fmt.Errorf("limit must be > 0")
 	}
-	if req.Limit > 25 {
-		req.Limit = 25
+	if req.Limit > maxLimit {
+		req.Limit = maxLimit
 	}
 	if req.Offset < 0 {
 		return nil, fmt.Errorf("offset must be > 0")
 	}
+	if req.AgeMin < 0 || req.AgeMax < 0 {
+		return nil, fmt.Errorf("age must be > 0")
+	}
+	if req.AgeMax > 0 && req.AgeMin > req.AgeMax {
+		return nil, fmt.Errorf("age range %d-%d is empty", req.AgeMin, req.AgeMax)
+	}
 
 	//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
 	req.Limit++
@@ -84,37 +129,74 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 	searcherParams.Add("limit", strconv.Itoa(req.Limit))
 	searcherParams.Add("offset", strconv.Itoa(req.Offset))
 	searcherParams.Add("query", req.Query)
-	searcherParams.Add("order_field", req.OrderField)
-	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
+	orderField, orderBy := sortParams(req)
+	searcherParams.Add("order_field", orderField)
+	searcherParams.Add("order_by", orderBy)
+	// фильтры отправляем, только если заданы, запрос без них тот же, что и раньше
+	if req.AgeMin > 0 {
+		searcherParams.Add("age_min", strconv.Itoa(req.AgeMin))
+	}
+	if req.AgeMax > 0 {
+		searcherParams.Add("age_max", strconv.Itoa(req.AgeMax))
+	}
+	if req.Gender != "" {
+		searcherParams.Add("gender", req.Gender)
+	}
+	if req.NamePrefix != "" {
+		searcherParams.Add("name_prefix", req.NamePrefix)
+	}
+
+	return srv.Retry.do(ctx, func() (*SearchResponse, error) {
+		if err := srv.Breaker.Allow(); err != nil {
+			return nil, err
+		}
+		result, err := srv.find(ctx, req, searcherParams)
+		srv.Breaker.Record(ctx, err)
+		return result, err
+	})
+}
 
-	searcherReq, err := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil)
+// find makes a single request to the search system.
+func (srv *SearchClient) find(ctx context.Context, req SearchRequest, searcherParams url.Values) (*SearchResponse, error) {
+	searcherReq, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"?"+searcherParams.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("bad request: %s", err)
+	}
 	searcherReq.Header.Add("AccessToken", srv.AccessToken)
-	resp, err := client.Do(searcherReq)
+	httpClient := srv.Client
+	if httpClient == nil {
+		httpClient = client
+	}
+	resp, err := httpClient.Do(searcherReq)
 	if err != nil {
-		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
-		}
-		return nil, fmt.Errorf("unknown error %s", err)
+		return nil, requestError(ctx, err, searcherParams)
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, requestError(ctx, err, searcherParams)
+	}
 
 	switch resp.StatusCode {
+	case http.StatusOK:
 	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad AccessToken")
-	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("SearchServer fatal error")
+		return nil, ErrUnauthorized
 	case http.StatusBadRequest:
 		errResp := SearchErrorResponse{}
 		err = json.Unmarshal(body, &errResp)
 		if err != nil {
-			return nil, fmt.Errorf("cant unpack error json: %s", err)
+			return nil, &ServerError{Status: resp.StatusCode, Body: string(body)}
 		}
-		fmt.Println(errResp.Error)
 		if errResp.Error == "ErrorBadOrderField" {
-			return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
+			field := errResp.Field
+			if field == "" {
+				field = searcherParams.Get("order_field")
+			}
+			return nil, ErrBadOrderField{Field: field}
 		}
-		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
+		return nil, &ServerError{Status: resp.StatusCode, Body: errResp.Error}
+	default:
+		return nil, &ServerError{Status: resp.StatusCode, Body: string(body)}
 	}
 
 	data := []User{}
@@ -133,3 +215,32 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 
 	return &result, err
 }
+
+// sortParams encodes sort keys as comma separated order_field and
+// order_by, a single key is encoded as before: order_field=Age&order_by=1.
+func sortParams(req SearchRequest) (string, string) {
+	if len(req.SortKeys) == 0 {
+		return req.OrderField, strconv.Itoa(req.OrderBy)
+	}
+	fields := make([]string, len(req.SortKeys))
+	orders := make([]string, len(req.SortKeys))
+	for i, key := range req.SortKeys {
+		fields[i] = key.Field
+		orders[i] = strconv.Itoa(key.Order)
+	}
+	return strings.Join(fields, ","), strings.Join(orders, ",")
+}
+
+// requestError tells a cancelled request from a timed out one.
+func requestError(ctx context.Context, err error, params url.Values) error {
+	switch ctx.Err() {
+	case context.Canceled:
+		return fmt.Errorf("request canceled for %s: %w", params.Encode(), context.Canceled)
+	case context.DeadlineExceeded:
+		return &TimeoutError{Query: params.Encode(), Err: err}
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return &TimeoutError{Query: params.Encode(), Err: err}
+	}
+	return fmt.Errorf("unknown error %w", err)
+}
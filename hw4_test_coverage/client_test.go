@@ -1,220 +1,54 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"encoding/xml"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
-)
-
-type Row struct {
-	Text          string `xml:",chardata" json:"-"`
-	ID            int    `xml:"id" json:"id"`
-	GUID          string `xml:"-" json:"guid"`
-	IsActive      string `xml:"-"  json:"is_active"`
-	Balance       string `xml:"-" json:"balance"`
-	Picture       string `xml:"-" json:"picture"`
-	Age           int    `xml:"age" json:"age"`
-	EyeColor      string `xml:"-" json:"eye_color"`
-	FirstName     string `xml:"first_name" json:"first_name"`
-	LastName      string `xml:"last_name" json:"last_name"`
-	Gender        string `xml:"gender" json:"gender"`
-	Company       string `xml:"-" json:"company"`
-	Email         string `xml:"-" json:"email"`
-	Phone         string `xml:"-" json:"phone"`
-	Address       string `xml:"-" json:"address"`
-	About         string `xml:"about" json:"about"`
-	Registered    string `xml:"-" json:"registered"`
-	FavoriteFruit string `xml:"-" json:"favorite_fruit"`
-}
-
-type Root struct {
-	XMLName xml.Name `xml:"root"`
-	Text    string   `xml:",chardata"`
-	Rows    []Row    `xml:"row"`
-}
 
-func FilterByQuery(query string, rows []Row) []Row {
-	res := make([]Row, 0, len(rows))
-	if query == "" {
-		return rows
-	}
+	"coursera_go/hw4_test_coverage/server"
+)
 
-	for _, row := range rows {
-		if strings.Contains(row.FirstName+" "+row.LastName, query) || strings.Contains(row.About, query) {
-			res = append(res, row)
-		}
+// reference is the server package over dataset.xml
+var reference = func() *server.SearchServer {
+	srv, err := server.NewSearchServer("dataset.xml")
+	if err != nil {
+		panic(err)
 	}
+	return srv
+}()
 
-	return res
-}
+// SearchService is the reference server plus failures the client has to
+// handle, picked by the query: timeout, wrong_json, internal and bad_user.
 func SearchService(w http.ResponseWriter, r *http.Request) {
-	session := r.Header.Get("AccessToken")
-	if session == "" {
-		http.Error(w, fmt.Sprintf("Bad AccessToken"), http.StatusUnauthorized)
-		return
-	}
-
-	w.Header().Add("Content-Type", "application/json")
-	query := r.URL.Query().Get("query")
-
-	if query == "timeout" {
+	switch r.URL.Query().Get("query") {
+	case "timeout":
 		time.Sleep(2 * time.Second)
-	}
-	if query == "wrong_json" {
-		writeBadError(w)
+	case "wrong_json":
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(`{BadJson}`)
 		return
-	}
-	if query == "internal" {
+	case "internal":
 		http.Error(w, "TestInternalServerError", http.StatusInternalServerError)
 		return
-	}
-	if query == "bad_user" {
+	case "bad_user":
 		json.NewEncoder(w).Encode(`dermeco`)
 		return
 	}
-
-	orderField := r.URL.Query().Get("order_field")
-	orderBy, err := strconv.Atoi(r.URL.Query().Get("order_by"))
-	if err != nil {
-		writeError(w, "order_by should be integer")
-		return
-	}
-	if orderBy != 1 && orderBy != 0 && orderBy != -1 {
-		writeError(w, "order_by should be 1,0 or -1")
-		return
-	}
-	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
-	if err != nil {
-		writeError(w, "offset should be integer")
-		return
-	}
-	if offset < 0 {
-		writeError(w, "offset should >  0")
-		return
-	}
-	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
-	if err != nil {
-		writeError(w, "limit should be integer")
-		return
-	}
-
-	data, err := ioutil.ReadFile("dataset.xml")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
-	root := &Root{}
-	err = xml.Unmarshal(data, root)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
-	res := FilterByQuery(query, root.Rows)
-
-	err = sorting(res, query, orderField, orderBy)
-	if err != nil {
-		writeError(w, err.Error())
-		return
-	}
-
-	// for i, row := range res {
-	// 	fmt.Println(i, row.ID, row.FirstName, row.LastName, row.Age)
-	// }
-	if offset >= len(res) {
-		w.Write(nil)
-	}
-	res = res[offset:]
-	if limit < len(res) {
-		res = res[:limit]
-	}
-	u := make([]User, 0, len(res))
-	for i := range res {
-		u = append(u, *toUser(&res[i]))
-	}
-
-	err = json.NewEncoder(w).Encode(u)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
-}
-func writeBadError(w http.ResponseWriter) {
-	w.WriteHeader(http.StatusBadRequest)
-	err := json.NewEncoder(w).Encode(`{BadJson}`)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
-}
-
-func writeError(w http.ResponseWriter, errText string) {
-	w.WriteHeader(http.StatusBadRequest)
-	err := json.NewEncoder(w).Encode(&SearchErrorResponse{Error: errText})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
-}
-
-func toUser(r *Row) *User {
-	return &User{
-		Id:     r.ID,
-		Name:   r.FirstName + " " + r.LastName,
-		Age:    r.Age,
-		About:  r.About,
-		Gender: r.Gender,
-	}
-}
-
-func sorting(rows []Row, query, orderField string, orderBy int) error {
-	switch orderField {
-	case "Name", "":
-		switch orderBy {
-		case -1:
-			sort.Slice(rows, func(i, j int) bool {
-				return rows[i].FirstName+" "+rows[i].LastName < rows[j].FirstName+" "+rows[j].LastName
-			})
-		case 1:
-			sort.Slice(rows, func(i, j int) bool {
-				return rows[i].FirstName+" "+rows[i].LastName > rows[j].FirstName+" "+rows[j].LastName
-			})
-		default:
-			return nil
-		}
-
-	case "Id":
-		switch orderBy {
-		case -1:
-			sort.Slice(rows, func(i, j int) bool {
-				return rows[i].ID < rows[j].ID
-			})
-		case 1:
-			sort.Slice(rows, func(i, j int) bool {
-				return rows[i].ID > rows[j].ID
-			})
-		default:
-			return nil
-		}
-	case "Age":
-		switch orderBy {
-		case -1:
-			sort.Slice(rows, func(i, j int) bool {
-				return rows[i].Age < rows[j].Age
-			})
-		case 1:
-			sort.Slice(rows, func(i, j int) bool {
-				return rows[i].Age > rows[j].Age
-			})
-		default:
-			return nil
-		}
-	default:
-		return fmt.Errorf("ErrorBadOrderField")
-	}
-	return nil
+	reference.ServeHTTP(w, r)
 }
 
 type TestCase struct {
@@ -491,3 +325,641 @@ func TestFindUsersUnknownError(t *testing.T) {
 		}
 	}
 }
+
+func TestFindUsersContextCanceled(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(SearchService))
+	defer s.Close()
+	srv := &SearchClient{AccessToken: "123", URL: s.URL}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := srv.FindUsersContext(ctx, SearchRequest{Limit: 5})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled error, got %#v", err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("canceled request reported as timeout: %s", err)
+	}
+}
+
+func TestFindUsersContextDeadline(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(SearchService))
+	srv := &SearchClient{AccessToken: "123", URL: s.URL}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := srv.FindUsersContext(ctx, SearchRequest{Limit: 5, Query: "timeout"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected timeout error, got %#v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("deadline of 50ms took %s", elapsed)
+	}
+}
+
+func TestFindUsersClientTimeout(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(SearchService))
+	srv := &SearchClient{
+		AccessToken: "123",
+		URL:         s.URL,
+		Client:      &http.Client{Timeout: 50 * time.Millisecond},
+	}
+	_, err := srv.FindUsers(SearchRequest{Limit: 5, Query: "timeout"})
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		t.Errorf("expected timeout error, got %#v", err)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestFindUsersTransport(t *testing.T) {
+	calls := 0
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		if r.Header.Get("AccessToken") != "123" {
+			t.Errorf("AccessToken header not sent")
+		}
+		body, _ := json.Marshal([]User{{Id: 7, Name: "Stub User"}})
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(bytes.NewReader(body)),
+			Request:    r,
+		}, nil
+	})
+	srv := &SearchClient{
+		AccessToken: "123",
+		URL:         "http://search.invalid/",
+		Client:      &http.Client{Transport: transport},
+	}
+	result, err := srv.FindUsers(SearchRequest{Limit: 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if calls != 1 || len(result.Users) != 1 || result.Users[0].Id != 7 {
+		t.Errorf("stub transport not used: %d calls, %+v", calls, result)
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errTest
+}
+
+func TestFindUsersBrokenRequest(t *testing.T) {
+	srv := &SearchClient{AccessToken: "123", URL: "http://bad host/"}
+	if _, err := srv.FindUsers(SearchRequest{Limit: 5}); err == nil {
+		t.Errorf("expected error for bad url, got nil")
+	}
+
+	srv = &SearchClient{
+		AccessToken: "123",
+		URL:         "http://search.invalid/",
+		Client: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(errReader{}), Request: r}, nil
+		})},
+	}
+	if _, err := srv.FindUsers(SearchRequest{Limit: 5}); err == nil {
+		t.Errorf("expected error for broken body, got nil")
+	}
+}
+
+func TestFindUsersTypedErrors(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(SearchService))
+	defer s.Close()
+	cases := []struct {
+		Token   string
+		Request SearchRequest
+		Check   func(error) bool
+	}{
+		{"", SearchRequest{Limit: 5}, func(err error) bool {
+			return errors.Is(err, ErrUnauthorized)
+		}},
+		{"123", SearchRequest{Limit: 5, OrderField: "Favno"}, func(err error) bool {
+			e := ErrBadOrderField{}
+			return errors.As(err, &e) && e.Field == "Favno" && err.Error() == "OrderField Favno invalid" &&
+				errors.Is(err, ErrBadOrderField{}) && !errors.Is(err, ErrBadOrderField{Field: "Id"})
+		}},
+		{"123", SearchRequest{Limit: 5, Query: "internal"}, func(err error) bool {
+			e := &ServerError{}
+			return errors.As(err, &e) && e.Status == http.StatusInternalServerError && e.Body == "TestInternalServerError\n"
+		}},
+		{"123", SearchRequest{Limit: 5, OrderBy: 2}, func(err error) bool {
+			e := &ServerError{}
+			return errors.As(err, &e) && e.Status == http.StatusBadRequest && e.Body == "order_by should be -1, 0 or 1"
+		}},
+		{"123", SearchRequest{Limit: 5, Query: "wrong_json"}, func(err error) bool {
+			e := &ServerError{}
+			return errors.As(err, &e) && e.Status == http.StatusBadRequest
+		}},
+	}
+	for i, item := range cases {
+		srv := &SearchClient{AccessToken: item.Token, URL: s.URL}
+		_, err := srv.FindUsers(item.Request)
+		if !item.Check(err) {
+			t.Errorf("[%d] unexpected error: %#v", i, err)
+		}
+	}
+}
+
+func TestFindUsersTimeoutError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(SearchService))
+	srv := &SearchClient{
+		AccessToken: "123",
+		URL:         s.URL,
+		Client:      &http.Client{Timeout: 50 * time.Millisecond},
+	}
+	_, err := srv.FindUsers(SearchRequest{Limit: 5, Query: "timeout"})
+	e := &TimeoutError{}
+	if !errors.As(err, &e) || !strings.Contains(err.Error(), "query=timeout") {
+		t.Fatalf("expected *TimeoutError, got %#v", err)
+	}
+	if e.Unwrap() == nil || !e.Timeout() || !e.Temporary() {
+		t.Errorf("TimeoutError lost its cause: %#v", e)
+	}
+}
+
+func TestServerErrorStatus(t *testing.T) {
+	srv := &SearchClient{
+		AccessToken: "123",
+		URL:         "http://search.invalid/",
+		Client: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			body := ioutil.NopCloser(strings.NewReader("Bad Gateway"))
+			return &http.Response{StatusCode: http.StatusBadGateway, Body: body, Request: r}, nil
+		})},
+	}
+	_, err := srv.FindUsers(SearchRequest{Limit: 5})
+	e := &ServerError{}
+	if !errors.As(err, &e) || e.Status != http.StatusBadGateway || e.Error() != "SearchServer error 502: Bad Gateway" {
+		t.Errorf("unexpected error: %#v", err)
+	}
+}
+
+// countingService counts requests to SearchService and fails those with
+// offset >= failFrom
+func countingService(calls *int32, failFrom int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		if offset, _ := strconv.Atoi(r.URL.Query().Get("offset")); offset >= failFrom {
+			http.Error(w, "TestInternalServerError", http.StatusInternalServerError)
+			return
+		}
+		SearchService(w, r)
+	}
+}
+
+func voluptateIDs(t *testing.T) []int {
+	ids := []int{}
+	for _, row := range server.FilterByQuery("voluptate", reference.Rows) {
+		ids = append(ids, row.ID)
+	}
+	return ids
+}
+
+func TestIterate(t *testing.T) {
+	want := voluptateIDs(t)
+	for _, prefetch := range []bool{false, true} {
+		var calls int32
+		s := httptest.NewServer(countingService(&calls, 1000))
+		srv := &SearchClient{AccessToken: "123", URL: s.URL}
+		it := srv.Iterate(context.Background(), SearchRequest{Limit: 4, Query: "voluptate", OrderField: "Id", OrderBy: -1})
+		it.Prefetch = prefetch
+		ids := []int{}
+		for it.Next() {
+			ids = append(ids, it.User().Id)
+		}
+		s.Close()
+		if it.Err() != nil {
+			t.Fatalf("[prefetch %v] unexpected error: %s", prefetch, it.Err())
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(want) {
+			t.Errorf("[prefetch %v] got ids %v, want %v", prefetch, ids, want)
+		}
+		if pages := int32((len(want) + 3) / 4); calls != pages {
+			t.Errorf("[prefetch %v] %d requests, want %d", prefetch, calls, pages)
+		}
+		if it.Next() {
+			t.Errorf("[prefetch %v] Next after the end returned true", prefetch)
+		}
+	}
+}
+
+func TestIterateLazy(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(countingService(&calls, 1000))
+	defer s.Close()
+	srv := &SearchClient{AccessToken: "123", URL: s.URL}
+	it := srv.Iterate(context.Background(), SearchRequest{Limit: 2, OrderField: "Id", OrderBy: -1})
+	if atomic.LoadInt32(&calls) != 0 {
+		t.Errorf("Iterate made a request before Next")
+	}
+	for i := 0; i < 2 && it.Next(); i++ {
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("%d requests for the first page, want 1", calls)
+	}
+	it.Close()
+	if it.Next() {
+		t.Errorf("Next after Close returned true")
+	}
+}
+
+func TestIteratePrefetch(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(countingService(&calls, 1000))
+	defer s.Close()
+	srv := &SearchClient{AccessToken: "123", URL: s.URL}
+	it := srv.Iterate(context.Background(), SearchRequest{Limit: 2, OrderField: "Id", OrderBy: -1})
+	it.Prefetch = true
+	defer it.Close()
+	if !it.Next() {
+		t.Fatalf("no users: %v", it.Err())
+	}
+	for i := 0; i < 100 && atomic.LoadInt32(&calls) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if calls != 2 {
+		t.Errorf("%d requests after the first user, want the second page prefetched", calls)
+	}
+}
+
+func TestIterateError(t *testing.T) {
+	for _, prefetch := range []bool{false, true} {
+		var calls int32
+		s := httptest.NewServer(countingService(&calls, 4))
+		srv := &SearchClient{AccessToken: "123", URL: s.URL}
+		it := srv.Iterate(context.Background(), SearchRequest{Limit: 2, OrderField: "Id", OrderBy: -1})
+		it.Prefetch = prefetch
+		n := 0
+		for it.Next() {
+			n++
+		}
+		s.Close()
+		e := &ServerError{}
+		if !errors.As(it.Err(), &e) || e.Status != http.StatusInternalServerError {
+			t.Errorf("[prefetch %v] expected *ServerError, got %#v", prefetch, it.Err())
+		}
+		if n != 4 {
+			t.Errorf("[prefetch %v] got %d users before the error, want 4", prefetch, n)
+		}
+	}
+}
+
+func TestIterateDefaultLimit(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(countingService(&calls, 1000))
+	defer s.Close()
+	srv := &SearchClient{AccessToken: "123", URL: s.URL}
+	it := srv.Iterate(context.Background(), SearchRequest{OrderField: "Id", OrderBy: -1})
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if it.Err() != nil || n != 35 || calls != 2 {
+		t.Errorf("got %d users in %d requests, err %v", n, calls, it.Err())
+	}
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+// flakyTransport fails the first len(errs) requests with errs and then
+// answers with users
+func flakyTransport(calls *int32, errs ...error) http.RoundTripper {
+	return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		n := int(atomic.AddInt32(calls, 1))
+		if n <= len(errs) {
+			return nil, errs[n-1]
+		}
+		body, _ := json.Marshal([]User{{Id: 1}, {Id: 2}})
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(body)), Request: r}, nil
+	})
+}
+
+func TestRetry(t *testing.T) {
+	var calls int32
+	srv := &SearchClient{
+		AccessToken: "123",
+		URL:         "http://search.invalid/",
+		Client:      &http.Client{Transport: flakyTransport(&calls, timeoutErr{}, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET})},
+		Retry:       &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, Jitter: 0.5},
+	}
+	result, err := srv.FindUsers(SearchRequest{Limit: 5})
+	if err != nil || len(result.Users) != 2 {
+		t.Fatalf("unexpected result %+v, error %v", result, err)
+	}
+	if calls != 3 {
+		t.Errorf("%d requests, want 3", calls)
+	}
+
+	// ошибки, не связанные с сетью, повторять бесполезно
+	for _, e := range []error{errTest, &net.DNSError{Err: "no such host", Name: "search.invalid", IsNotFound: true}} {
+		calls = 0
+		srv.Client = &http.Client{Transport: flakyTransport(&calls, e)}
+		if _, err := srv.FindUsers(SearchRequest{Limit: 5}); err == nil || calls != 1 {
+			t.Errorf("%v: %d requests, error %v", e, calls, err)
+		}
+	}
+	srv = &SearchClient{AccessToken: "123", URL: "ftp://search.invalid/", Retry: &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second}}
+	start := time.Now()
+	if _, err := srv.FindUsers(SearchRequest{Limit: 5}); err == nil || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("bad scheme retried: error %v after %s", err, time.Since(start))
+	}
+}
+
+func TestRetryServer(t *testing.T) {
+	cases := []struct {
+		Token    string
+		Request  SearchRequest
+		FailFrom int
+		Calls    int32
+	}{
+		// 5xx повторяем, пока не кончатся попытки
+		{"123", SearchRequest{Limit: 5}, 0, 3},
+		// ошибки запроса повторять бесполезно
+		{"", SearchRequest{Limit: 5}, 1000, 1},
+		{"123", SearchRequest{Limit: 5, OrderField: "Favno"}, 1000, 1},
+		{"123", SearchRequest{Limit: 5, Query: "wrong_json"}, 1000, 1},
+		{"123", SearchRequest{Limit: 5, Query: "bad_user"}, 1000, 1},
+	}
+	for i, item := range cases {
+		var calls int32
+		s := httptest.NewServer(countingService(&calls, item.FailFrom))
+		srv := &SearchClient{
+			AccessToken: item.Token,
+			URL:         s.URL,
+			Retry:       &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		}
+		_, err := srv.FindUsers(item.Request)
+		s.Close()
+		if err == nil {
+			t.Errorf("[%d] expected error, got nil", i)
+		}
+		if calls != item.Calls {
+			t.Errorf("[%d] %d requests, want %d", i, calls, item.Calls)
+		}
+	}
+}
+
+func TestRetryContext(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(countingService(&calls, 0))
+	defer s.Close()
+	srv := &SearchClient{AccessToken: "123", URL: s.URL, Retry: &RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := srv.FindUsersContext(ctx, SearchRequest{Limit: 5})
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "TestInternalServerError") {
+		t.Errorf("expected the deadline with the last error, got %v", err)
+	}
+	if elapsed := time.Since(start); calls != 1 || elapsed > 500*time.Millisecond {
+		t.Errorf("%d requests in %s, want the backoff stopped by the deadline", calls, elapsed)
+	}
+
+	calls = 0
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := srv.FindUsersContext(ctx, SearchRequest{Limit: 5}); !errors.Is(err, context.Canceled) || calls != 0 {
+		t.Errorf("canceled request retried: %d requests, error %v", calls, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := &RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for i, w := range want {
+		if got := p.Backoff(i + 1); got != w*time.Millisecond {
+			t.Errorf("Backoff(%d) = %s, want %s", i+1, got, w*time.Millisecond)
+		}
+	}
+	p = &RetryPolicy{BaseDelay: 10 * time.Millisecond, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if got := p.Backoff(3); got < 20*time.Millisecond || got > 40*time.Millisecond {
+			t.Fatalf("Backoff(3) with jitter = %s, want 20ms..40ms", got)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+	changes := []string{}
+	b.OnStateChange = func(from, to BreakerState) {
+		changes = append(changes, from.String()+">"+to.String())
+	}
+	ctx := context.Background()
+	serverErr := &ServerError{Status: http.StatusInternalServerError}
+
+	steps := []struct {
+		Err   error
+		State BreakerState
+	}{
+		{serverErr, BreakerClosed},
+		// плохой запрос значит, что система жива
+		{ErrUnauthorized, BreakerClosed},
+		{serverErr, BreakerClosed},
+		{serverErr, BreakerOpen},
+	}
+	for i, step := range steps {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("[%d] unexpected %v", i, err)
+		}
+		b.Record(ctx, step.Err)
+		if b.State() != step.State {
+			t.Errorf("[%d] state %s, want %s", i, b.State(), step.State)
+		}
+	}
+	if err := b.Allow(); err != ErrCircuitOpen {
+		t.Errorf("open breaker allowed a request: %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil || b.State() != BreakerHalfOpen {
+		t.Fatalf("breaker did not half-open after cooldown: %v, %s", err, b.State())
+	}
+	if err := b.Allow(); err != ErrCircuitOpen {
+		t.Errorf("half-open breaker allowed a second probe: %v", err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	b.Record(canceled, context.Canceled)
+	if err := b.Allow(); err != nil || b.State() != BreakerHalfOpen {
+		t.Errorf("canceled probe was counted: %v, %s", err, b.State())
+	}
+	b.Record(ctx, serverErr)
+	if b.State() != BreakerOpen {
+		t.Errorf("failed probe did not open the breaker: %s", b.State())
+	}
+
+	now = now.Add(time.Minute)
+	b.Allow()
+	b.Record(ctx, nil)
+	if b.State() != BreakerClosed {
+		t.Errorf("good probe did not close the breaker: %s", b.State())
+	}
+	want := "closed>open open>half-open half-open>open open>half-open half-open>closed"
+	if got := strings.Join(changes, " "); got != want {
+		t.Errorf("state changes %q, want %q", got, want)
+	}
+	if s := BreakerState(7).String(); s != "BreakerState(7)" {
+		t.Errorf("unknown state printed as %q", s)
+	}
+}
+
+func TestFindUsersBreaker(t *testing.T) {
+	var calls int32
+	s := httptest.NewServer(countingService(&calls, 0))
+	defer s.Close()
+	srv := &SearchClient{
+		AccessToken: "123",
+		URL:         s.URL,
+		Retry:       &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		Breaker:     NewCircuitBreaker(2, time.Hour),
+	}
+	_, err := srv.FindUsers(SearchRequest{Limit: 5})
+	if err != ErrCircuitOpen || calls != 2 {
+		t.Errorf("%d requests, error %v; want 2 and the breaker open", calls, err)
+	}
+	if _, err := srv.FindUsers(SearchRequest{Limit: 5}); err != ErrCircuitOpen || calls != 2 {
+		t.Errorf("open breaker made a request: %d requests, error %v", calls, err)
+	}
+}
+
+func TestFindUsersParams(t *testing.T) {
+	cases := []struct {
+		Request SearchRequest
+		Query   string
+	}{
+		// без новых полей запрос тот же, что и раньше
+		{SearchRequest{Limit: 5, Query: "x", OrderField: "Age", OrderBy: 1},
+			"limit=6&offset=0&order_by=1&order_field=Age&query=x"},
+		{SearchRequest{Limit: 5, OrderField: "Id", SortKeys: []SortKey{{"Age", OrderByDesc}, {"Name", OrderByAsc}}},
+			"limit=6&offset=0&order_by=1%2C-1&order_field=Age%2CName&query="},
+		{SearchRequest{Limit: 5, AgeMin: 20, AgeMax: 30, Gender: "female", NamePrefix: "Bo"},
+			"age_max=30&age_min=20&gender=female&limit=6&name_prefix=Bo&offset=0&order_by=0&order_field=&query="},
+	}
+	for i, item := range cases {
+		var got string
+		srv := &SearchClient{
+			AccessToken: "123",
+			URL:         "http://search.invalid/",
+			Client: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				got = r.URL.RawQuery
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("[]")), Request: r}, nil
+			})},
+		}
+		if _, err := srv.FindUsers(item.Request); err != nil {
+			t.Errorf("[%d] unexpected error: %s", i, err)
+		}
+		if got != item.Query {
+			t.Errorf("[%d] query %q, want %q", i, got, item.Query)
+		}
+	}
+}
+
+func TestFindUsersBadFilters(t *testing.T) {
+	srv := &SearchClient{AccessToken: "123", URL: "http://search.invalid/"}
+	for i, req := range []SearchRequest{
+		{Limit: 5, AgeMin: -1},
+		{Limit: 5, AgeMax: -1},
+		{Limit: 5, AgeMin: 40, AgeMax: 30},
+	} {
+		if _, err := srv.FindUsers(req); err == nil {
+			t.Errorf("[%d] expected error, got nil", i)
+		}
+	}
+}
+
+func TestFindUsersBadSortKey(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(SearchService))
+	defer s.Close()
+	srv := &SearchClient{AccessToken: "123", URL: s.URL}
+	_, err := srv.FindUsers(SearchRequest{Limit: 5, SortKeys: []SortKey{{"Id", OrderByAsc}, {"Favno", OrderByAsc}}})
+	if !errors.Is(err, ErrBadOrderField{Field: "Favno"}) {
+		t.Errorf("unexpected error: %#v", err)
+	}
+
+	// сервер без поля Field - называем все ключи
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&SearchErrorResponse{Error: "ErrorBadOrderField"})
+	}))
+	defer old.Close()
+	srv.URL = old.URL
+	_, err = srv.FindUsers(SearchRequest{Limit: 5, SortKeys: []SortKey{{"Id", OrderByAsc}, {"Favno", OrderByAsc}}})
+	if !errors.Is(err, ErrBadOrderField{Field: "Id,Favno"}) {
+		t.Errorf("unexpected error: %#v", err)
+	}
+}
+
+func TestFindUsersFiltersAndSortKeys(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(SearchService))
+	defer s.Close()
+	srv := &SearchClient{AccessToken: "123", URL: s.URL}
+	req := SearchRequest{
+		Limit:      10,
+		AgeMin:     25,
+		AgeMax:     35,
+		Gender:     "male",
+		NamePrefix: "B",
+		SortKeys:   []SortKey{{"Age", OrderByDesc}, {"Name", OrderByAsc}},
+	}
+
+	// ожидаемое считаем прямо по строкам датасета
+	want := []User{}
+	for _, row := range reference.Rows {
+		name := row.Name()
+		if row.Age >= req.AgeMin && row.Age <= req.AgeMax && row.Gender == req.Gender && strings.HasPrefix(name, req.NamePrefix) {
+			want = append(want, User{Id: row.ID, Name: name, Age: row.Age, About: row.About, Gender: row.Gender})
+		}
+	}
+	sort.SliceStable(want, func(i, j int) bool {
+		if want[i].Age != want[j].Age {
+			return want[i].Age > want[j].Age
+		}
+		return want[i].Name < want[j].Name
+	})
+	if len(want) < 2 {
+		t.Fatalf("dataset has %d matching users, the test needs more", len(want))
+	}
+
+	var got []User
+	for req.Offset = 0; ; req.Offset += req.Limit {
+		req.Limit = 1
+		result, err := srv.FindUsers(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, result.Users...)
+		if !result.NextPage {
+			break
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	req.Offset, req.AgeMin, req.AgeMax = 0, 35, 25
+	if _, err := srv.FindUsers(req); err == nil {
+		t.Error("expected error for an empty age range")
+	}
+	// клиент не пускает такой запрос, проверяем сервер напрямую
+	httpReq, _ := http.NewRequest(http.MethodGet, s.URL+"?age_min=35&age_max=25", nil)
+	httpReq.Header.Set("AccessToken", "123")
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("empty age range: status %d, want 400", resp.StatusCode)
+	}
+}
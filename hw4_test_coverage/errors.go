@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrUnauthorized is returned when the search system rejects AccessToken.
+var ErrUnauthorized = errors.New("Bad AccessToken")
+
+// ErrCircuitOpen is returned without a request while CircuitBreaker is
+// open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// ErrBadOrderField is returned when the search system can not order by
+// Field. errors.Is(err, ErrBadOrderField{}) matches any field.
+type ErrBadOrderField struct {
+	Field string
+}
+
+func (e ErrBadOrderField) Error() string {
+	return fmt.Sprintf("OrderField %s invalid", e.Field)
+}
+
+func (e ErrBadOrderField) Is(target error) bool {
+	t, ok := target.(ErrBadOrderField)
+	return ok && (t.Field == "" || t.Field == e.Field)
+}
+
+// ServerError is an unexpected answer of the search system: a 500, a bad
+// request we do not know or an error body we can not parse.
+type ServerError struct {
+	Status int
+	Body   string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("SearchServer error %d: %s", e.Status, e.Body)
+}
+
+// TimeoutError is returned when a request does not finish in time, either
+// by the deadline of its context or by the timeout of the http client. It
+// matches context.DeadlineExceeded.
+type TimeoutError struct {
+	// Query is the encoded query string of the request
+	Query string
+	Err   error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timeout for %s", e.Query)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// Timeout makes TimeoutError a net.Error.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+func (e *TimeoutError) Temporary() bool {
+	return true
+}
@@ -0,0 +1,105 @@
+package main
+
+import "context"
+
+// UserIterator walks over all users found by a request page by page:
+//
+//	it := srv.Iterate(ctx, SearchRequest{Query: "voluptate"})
+//	defer it.Close()
+//	for it.Next() {
+//		user := it.User()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Pages are fetched lazily when the previous one is used up.
+type UserIterator struct {
+	// Prefetch fetches the next page in the background while the current
+	// one is iterated
+	Prefetch bool
+
+	srv    *SearchClient
+	ctx    context.Context
+	cancel context.CancelFunc
+	req    SearchRequest
+
+	users []User
+	user  User
+	more  bool
+	next  chan page
+	err   error
+}
+
+type page struct {
+	resp *SearchResponse
+	err  error
+}
+
+// Iterate returns an iterator over users found by req starting at
+// req.Offset. req.Limit is the page size, 0 means the maximum of 25.
+func (srv *SearchClient) Iterate(ctx context.Context, req SearchRequest) *UserIterator {
+	if req.Limit <= 0 || req.Limit > maxLimit {
+		req.Limit = maxLimit
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &UserIterator{srv: srv, ctx: ctx, cancel: cancel, req: req, more: true}
+}
+
+// Next advances to the next user, fetching a page if needed. It returns
+// false when there are no more users or an error occurred.
+func (it *UserIterator) Next() bool {
+	for len(it.users) == 0 {
+		if it.err != nil || !it.more {
+			it.Close()
+			return false
+		}
+		resp, err := it.fetch()
+		if err != nil {
+			it.err = err
+			it.Close()
+			return false
+		}
+		it.users = resp.Users
+		it.more = resp.NextPage && len(resp.Users) > 0
+		it.req.Offset += len(resp.Users)
+		if it.more && it.Prefetch {
+			// канал с буфером, чтобы горутина не зависла после Close
+			it.next = make(chan page, 1)
+			go func(next chan page, req SearchRequest) {
+				resp, err := it.srv.FindUsersContext(it.ctx, req)
+				next <- page{resp, err}
+			}(it.next, it.req)
+		}
+	}
+	it.user = it.users[0]
+	it.users = it.users[1:]
+	return true
+}
+
+func (it *UserIterator) fetch() (*SearchResponse, error) {
+	if it.next != nil {
+		p := <-it.next
+		it.next = nil
+		return p.resp, p.err
+	}
+	return it.srv.FindUsersContext(it.ctx, it.req)
+}
+
+// User returns the current user.
+func (it *UserIterator) User() User {
+	return it.user
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *UserIterator) Err() error {
+	return it.err
+}
+
+// Close stops the iteration and a prefetch in flight. Next returns false
+// after Close.
+func (it *UserIterator) Close() {
+	it.cancel()
+	it.more = false
+	it.users = nil
+}
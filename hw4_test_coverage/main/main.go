@@ -1,42 +1,26 @@
 package main
 
-// type Row struct {
-// 	Text          string `xml:",chardata" json:"-"`
-// 	ID            int    `xml:"id" json:"id"`
-// 	GUID          string `xml:"guid" json:"guid"`
-// 	IsActive      string `xml:"isActive"  json:"is_active"`
-// 	Balance       string `xml:"balance" json:"balance"`
-// 	Picture       string `xml:"picture" json:"picture"`
-// 	Age           int    `xml:"age" json:"age"`
-// 	EyeColor      string `xml:"eyeColor" json:"eye_color"`
-// 	FirstName     string `xml:"first_name" json:"first_name"`
-// 	LastName      string `xml:"last_name" json:"last_name"`
-// 	Gender        string `xml:"gender" json:"gender"`
-// 	Company       string `xml:"company" json:"company"`
-// 	Email         string `xml:"email" json:"email"`
-// 	Phone         string `xml:"phone" json:"phone"`
-// 	Address       string `xml:"address" json:"address"`
-// 	About         string `xml:"about" json:"about"`
-// 	Registered    string `xml:"registered" json:"registered"`
-// 	FavoriteFruit string `xml:"favoriteFruit" json:"favorite_fruit"`
-// }
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
 
-// type Root struct {
-// 	XMLName xml.Name `xml:"root"`
-// 	Text    string   `xml:",chardata"`
-// 	Rows    []Row    `xml:"row"`
-// }
+	"coursera_go/hw4_test_coverage/server"
+)
 
+// go run ./main -dataset dataset.xml
 func main() {
-	// query := "voluptate"
-	// // orderField := []string{"Id", "Name", "Age", ""}
-	// // offset := []int{3, 5, 10}
-	// // order := []int{-1, 0, 1}
-	// // limit := []int{5, 10, 25}
+	dataset := flag.String("dataset", "dataset.xml", "path to dataset.xml")
+	addr := flag.String("addr", ":8080", "address to listen on")
+	token := flag.String("token", "", "accepted AccessToken, any non-empty if not set")
+	flag.Parse()
 
-	// result := SearchService(query, "Id", 0, 2, 10)
-	// fmt.Println()
-	// for i, row := range result {
-	// 	fmt.Println(i, row.ID, row.FirstName, row.LastName, row.Age)
-	// }
+	srv, err := server.NewSearchServer(*dataset)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv.Token = *token
+	fmt.Printf("serving %d rows from %s on %s\n", len(srv.Rows), *dataset, *addr)
+	log.Fatal(http.ListenAndServe(*addr, srv))
 }
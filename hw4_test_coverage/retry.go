@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// RetryPolicy repeats failed searches. Searches are GETs and safe to
+// repeat, only failures of the search system itself are retried: timeouts,
+// refused or broken connections and 5xx answers. Errors of the request
+// like a bad URL are not.
+type RetryPolicy struct {
+	// MaxAttempts counts the first request too, 1 or less means no retries
+	MaxAttempts int
+	// BaseDelay is the pause before the first retry, doubled every next one
+	BaseDelay time.Duration
+	// MaxDelay caps the pause, no cap if zero
+	MaxDelay time.Duration
+	// Jitter is the random part of a pause, from 0 to 1: with 0.5 the
+	// pause is between half and all of the computed one
+	Jitter float64
+}
+
+// DefaultRetryPolicy makes 3 attempts with pauses of about 50ms and 100ms.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   50 * time.Millisecond,
+	MaxDelay:    time.Second,
+	Jitter:      0.5,
+}
+
+// Backoff returns the pause before the attempt-th retry, starting from 1.
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay == 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(p.Jitter * rand.Float64() * float64(delay))
+	}
+	return delay
+}
+
+// do calls find until it succeeds, fails for good, runs out of attempts
+// or ctx is done. If ctx ends during a pause the error wraps ctx.Err().
+// A nil policy calls find once.
+func (p *RetryPolicy) do(ctx context.Context, find func() (*SearchResponse, error)) (*SearchResponse, error) {
+	attempts := 1
+	if p != nil && p.MaxAttempts > 1 {
+		attempts = p.MaxAttempts
+	}
+	for attempt := 1; ; attempt++ {
+		result, err := find()
+		if err == nil || attempt >= attempts || !retryable(err) {
+			return result, err
+		}
+		timer := time.NewTimer(p.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w while waiting to retry, last error: %v", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether err is a failure of the search system rather
+// than of the request.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		return serverErr.Status >= 500
+	}
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	// несуществующий хост не появится от повтора, а сбой DNS может пройти
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary
+	}
+	// соединение не установилось или оборвалось
+	var opErr *net.OpError
+	return errors.As(err, &opErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters, the usual defaults
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// stopWords are too common to tell texts apart. dataset.xml is lorem
+// ipsum, so Latin function words are here besides English ones.
+var stopWords = make(map[string]bool)
+
+func init() {
+	words := "a an and are as at be by for from in is it of on or the to with " +
+		"ad cum et ex non qui sed ut"
+	for _, w := range strings.Fields(words) {
+		stopWords[w] = true
+	}
+}
+
+// tokenize splits text into lower-cased words without stop words.
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+	res := words[:0]
+	for _, w := range words {
+		if !stopWords[w] {
+			res = append(res, w)
+		}
+	}
+	return res
+}
+
+type posting struct {
+	doc  int
+	freq int
+}
+
+// Index is an inverted index over texts ranking them with BM25.
+type Index struct {
+	postings  map[string][]posting
+	lengths   []int
+	avgLength float64
+}
+
+// Hit is a text found by Search, Doc is its position in the indexed
+// texts.
+type Hit struct {
+	Doc   int
+	Score float64
+}
+
+// NewIndex indexes texts.
+func NewIndex(texts []string) *Index {
+	idx := &Index{postings: make(map[string][]posting), lengths: make([]int, len(texts))}
+	total := 0
+	for doc, text := range texts {
+		words := tokenize(text)
+		freqs := make(map[string]int)
+		for _, w := range words {
+			freqs[w]++
+		}
+		for w, freq := range freqs {
+			idx.postings[w] = append(idx.postings[w], posting{doc, freq})
+		}
+		idx.lengths[doc] = len(words)
+		total += len(words)
+	}
+	if len(texts) > 0 {
+		idx.avgLength = float64(total) / float64(len(texts))
+	}
+	return idx
+}
+
+// Search returns texts containing any word of query, the best matches
+// first. Equal scores keep the order of texts.
+func (idx *Index) Search(query string) []Hit {
+	scores := make(map[int]float64)
+	seen := make(map[string]bool)
+	n := float64(len(idx.lengths))
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		postings := idx.postings[term]
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for _, p := range postings {
+			tf := float64(p.freq)
+			norm := 1 - bm25B + bm25B*float64(idx.lengths[p.doc])/idx.avgLength
+			scores[p.doc] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+	hits := make([]Hit, 0, len(scores))
+	for doc, score := range scores {
+		hits = append(hits, Hit{doc, score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Doc < hits[j].Doc
+	})
+	return hits
+}
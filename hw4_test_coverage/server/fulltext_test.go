@@ -0,0 +1,49 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("Sint ET voluptate, commodo.\nIn 2014-dolor")
+	want := []string{"sint", "voluptate", "commodo", "2014", "dolor"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenize = %q, want %q", got, want)
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	idx := NewIndex([]string{
+		"alpha beta gamma delta",
+		"alpha alpha alpha beta gamma delta",
+		"alpha beta",
+		"gamma delta epsilon",
+		"alpha zeta",
+	})
+	cases := []struct {
+		query string
+		docs  []int
+	}{
+		// чаще встречается - выше, при равной частоте выше короткий текст,
+		// при равном счёте порядок текстов
+		{"alpha", []int{1, 2, 4, 0}},
+		// редкое слово весит больше частого
+		{"alpha epsilon", []int{3, 1, 2, 4, 0}},
+		{"Zeta ZETA", []int{4}},
+		{"the and", []int{}},
+		{"", []int{}},
+	}
+	for _, c := range cases {
+		docs := []int{}
+		for _, hit := range idx.Search(c.query) {
+			docs = append(docs, hit.Doc)
+		}
+		if !reflect.DeepEqual(docs, c.docs) {
+			t.Errorf("[%s] got %v, want %v", c.query, docs, c.docs)
+		}
+	}
+	if hits := NewIndex(nil).Search("alpha"); len(hits) != 0 {
+		t.Errorf("empty index found %v", hits)
+	}
+}
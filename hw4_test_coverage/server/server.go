@@ -0,0 +1,366 @@
+// Package server is the reference SearchServer over dataset.xml, main
+// serves it and the client tests run against it.
+package server
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Row is a user record of dataset.xml, fields the search does not use
+// are not decoded.
+type Row struct {
+	ID        int    `xml:"id"`
+	Age       int    `xml:"age"`
+	FirstName string `xml:"first_name"`
+	LastName  string `xml:"last_name"`
+	Gender    string `xml:"gender"`
+	About     string `xml:"about"`
+}
+
+// Name is what query and order_field=Name look at.
+func (r *Row) Name() string {
+	return r.FirstName + " " + r.LastName
+}
+
+type Root struct {
+	XMLName xml.Name `xml:"root"`
+	Rows    []Row    `xml:"row"`
+}
+
+// User is a found row as the client expects it.
+type User struct {
+	Id     int
+	Name   string
+	Age    int
+	About  string
+	Gender string
+}
+
+type SearchErrorResponse struct {
+	Error string
+	// Field is the order_field key rejected with ErrorBadOrderField
+	Field string `json:",omitempty"`
+}
+
+const (
+	OrderByAsc  = -1
+	OrderByAsIs = 0
+	OrderByDesc = 1
+
+	// ErrorBadOrderField is the error the client checks for
+	ErrorBadOrderField = "ErrorBadOrderField"
+
+	defaultLimit = 25
+)
+
+// LoadDataset reads all rows of an xml dataset.
+func LoadDataset(path string) ([]Row, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	root := &Root{}
+	if err := xml.NewDecoder(file).Decode(root); err != nil {
+		return nil, err
+	}
+	return root.Rows, nil
+}
+
+// SearchServer answers SearchClient requests over rows loaded once.
+//
+// Parameters: query (substring of Name or About), order_field (Id, Name,
+// Age, empty means Name), order_by (-1 ascending, 0 as is, 1 descending),
+// limit and offset. order_field and order_by may list several keys
+// separated by commas. Filters age_min, age_max, gender and name_prefix
+// apply if set. order_field=relevance ranks rows by the words of query
+// found in About, the best matches first unless order_by is -1.
+//
+// A request without the AccessToken header (or with a wrong one if Token
+// is set) gets 401, bad parameters get 400 with SearchErrorResponse,
+// failures to answer get 500.
+type SearchServer struct {
+	// Rows should not change, the full-text index is built over them on
+	// the first ranked search
+	Rows []Row
+	// Token is the only accepted AccessToken, any non-empty one if empty
+	Token string
+
+	indexOnce sync.Once
+	index     *Index
+}
+
+// NewSearchServer loads the dataset at path.
+func NewSearchServer(path string) (*SearchServer, error) {
+	rows, err := LoadDataset(path)
+	if err != nil {
+		return nil, err
+	}
+	return &SearchServer{Rows: rows}, nil
+}
+
+// fullText returns the index over About of Rows, building it once.
+func (s *SearchServer) fullText() *Index {
+	s.indexOnce.Do(func() {
+		about := make([]string, len(s.Rows))
+		for i := range s.Rows {
+			about[i] = s.Rows[i].About
+		}
+		s.index = NewIndex(about)
+	})
+	return s.index
+}
+
+func (s *SearchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("AccessToken")
+	if token == "" || s.Token != "" && token != s.Token {
+		writeError(w, http.StatusUnauthorized, "Bad AccessToken")
+		return
+	}
+
+	params := r.URL.Query()
+	limit, err := intParam(params.Get("limit"), defaultLimit)
+	if err != nil || limit < 0 {
+		writeError(w, http.StatusBadRequest, "limit should be a non-negative integer")
+		return
+	}
+	offset, err := intParam(params.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "offset should be a non-negative integer")
+		return
+	}
+	// scores заполняется после разбора, сортировка по relevance читает его
+	scores := make(map[int]float64)
+	keys, err := parseSortKeys(params.Get("order_field"), params.Get("order_by"), scores)
+	if field, ok := err.(badOrderField); ok {
+		writeResponse(w, http.StatusBadRequest, &SearchErrorResponse{Error: ErrorBadOrderField, Field: string(field)})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter, err := parseFilter(params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := params.Get("query")
+	var rows []Row
+	if ranked(params.Get("order_field")) && query != "" {
+		for _, hit := range s.fullText().Search(query) {
+			row := s.Rows[hit.Doc]
+			rows = append(rows, row)
+			scores[row.ID] = hit.Score
+		}
+	} else {
+		rows = FilterByQuery(query, s.Rows)
+	}
+	rows = filter.apply(rows)
+	if len(keys) > 0 {
+		// Rows общие для всех запросов, сортируем копию
+		rows = append([]Row(nil), rows...)
+		sort.SliceStable(rows, func(i, j int) bool {
+			for _, compare := range keys {
+				if c := compare(&rows[i], &rows[j]); c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+	if offset > len(rows) {
+		offset = len(rows)
+	}
+	rows = rows[offset:]
+	if limit < len(rows) {
+		rows = rows[:limit]
+	}
+
+	users := make([]User, 0, len(rows))
+	for i := range rows {
+		users = append(users, User{
+			Id:     rows[i].ID,
+			Name:   rows[i].Name(),
+			Age:    rows[i].Age,
+			About:  rows[i].About,
+			Gender: rows[i].Gender,
+		})
+	}
+	body, err := json.Marshal(users)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// compareFunc is negative if a goes before b, positive if after
+type compareFunc func(a, b *Row) int
+
+var orderFields = map[string]compareFunc{
+	"":     compareNames,
+	"Name": compareNames,
+	"Id":   func(a, b *Row) int { return a.ID - b.ID },
+	"Age":  func(a, b *Row) int { return a.Age - b.Age },
+}
+
+func compareNames(a, b *Row) int {
+	return strings.Compare(a.Name(), b.Name())
+}
+
+func compareScores(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// relevanceField orders by BM25 score of query, scores are by row ID
+const relevanceField = "relevance"
+
+func ranked(fields string) bool {
+	for _, name := range strings.Split(fields, ",") {
+		if name == relevanceField {
+			return true
+		}
+	}
+	return false
+}
+
+// badOrderField is the order_field key parseSortKeys does not know.
+type badOrderField string
+
+func (f badOrderField) Error() string {
+	return ErrorBadOrderField
+}
+
+// parseSortKeys parses order_field and order_by, both may list several
+// keys separated by commas: order_field=Age,Name&order_by=1,-1. Keys
+// ordered as is are left out, except relevance which is best first by
+// default.
+func parseSortKeys(fields, orders string, scores map[int]float64) ([]compareFunc, error) {
+	names := strings.Split(fields, ",")
+	dirs := make([]int, len(names))
+	if orders != "" {
+		values := strings.Split(orders, ",")
+		if len(values) != len(names) {
+			return nil, errors.New("order_by should have a value for every order_field")
+		}
+		for i, v := range values {
+			dir, err := strconv.Atoi(v)
+			if err != nil || dir < OrderByAsc || dir > OrderByDesc {
+				return nil, errors.New("order_by should be -1, 0 or 1")
+			}
+			dirs[i] = dir
+		}
+	}
+	keys := []compareFunc{}
+	for i, name := range names {
+		compare, ok := orderFields[name]
+		if name == relevanceField {
+			compare, ok = func(a, b *Row) int { return compareScores(scores[a.ID], scores[b.ID]) }, true
+			if dirs[i] == OrderByAsIs {
+				dirs[i] = OrderByDesc
+			}
+		}
+		if !ok {
+			return nil, badOrderField(name)
+		}
+		switch dirs[i] {
+		case OrderByAsc:
+			keys = append(keys, compare)
+		case OrderByDesc:
+			keys = append(keys, func(a, b *Row) int { return compare(b, a) })
+		}
+	}
+	return keys, nil
+}
+
+// rowFilter keeps rows matching all set fields
+type rowFilter struct {
+	ageMin, ageMax int
+	gender         string
+	namePrefix     string
+}
+
+func parseFilter(params url.Values) (*rowFilter, error) {
+	f := &rowFilter{gender: params.Get("gender"), namePrefix: params.Get("name_prefix")}
+	var err error
+	if f.ageMin, err = intParam(params.Get("age_min"), 0); err != nil || f.ageMin < 0 {
+		return nil, errors.New("age_min should be a non-negative integer")
+	}
+	if f.ageMax, err = intParam(params.Get("age_max"), 0); err != nil || f.ageMax < 0 {
+		return nil, errors.New("age_max should be a non-negative integer")
+	}
+	if f.ageMax > 0 && f.ageMin > f.ageMax {
+		return nil, errors.New("age_min should not be greater than age_max")
+	}
+	if f.gender != "" && f.gender != "male" && f.gender != "female" {
+		return nil, errors.New("gender should be male or female")
+	}
+	return f, nil
+}
+
+func (f *rowFilter) apply(rows []Row) []Row {
+	if *f == (rowFilter{}) {
+		return rows
+	}
+	res := make([]Row, 0, len(rows))
+	for i := range rows {
+		r := &rows[i]
+		if r.Age < f.ageMin || f.ageMax > 0 && r.Age > f.ageMax ||
+			f.gender != "" && r.Gender != f.gender ||
+			!strings.HasPrefix(r.Name(), f.namePrefix) {
+			continue
+		}
+		res = append(res, *r)
+	}
+	return res
+}
+
+// FilterByQuery returns rows with query in Name or About, all rows if
+// query is empty.
+func FilterByQuery(query string, rows []Row) []Row {
+	if query == "" {
+		return rows
+	}
+	res := make([]Row, 0, len(rows))
+	for i := range rows {
+		if strings.Contains(rows[i].Name(), query) || strings.Contains(rows[i].About, query) {
+			res = append(res, rows[i])
+		}
+	}
+	return res
+}
+
+func intParam(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func writeError(w http.ResponseWriter, status int, errText string) {
+	writeResponse(w, status, &SearchErrorResponse{Error: errText})
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp *SearchErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func newTestServer(t *testing.T) *SearchServer {
+	srv, err := NewSearchServer("../dataset.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func get(t *testing.T, srv http.Handler, token, query string) (int, []byte) {
+	req := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+	if token != "" {
+		req.Header.Set("AccessToken", token)
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.Bytes()
+}
+
+func TestLoadDataset(t *testing.T) {
+	srv := newTestServer(t)
+	if len(srv.Rows) != 35 {
+		t.Fatalf("loaded %d rows, want 35", len(srv.Rows))
+	}
+	if srv.Rows[0].ID != 0 || srv.Rows[0].Name() != "Boyd Wolf" {
+		t.Errorf("first row %+v", srv.Rows[0])
+	}
+	if _, err := NewSearchServer("missing.xml"); err == nil {
+		t.Error("expected an error for a missing dataset")
+	}
+}
+
+func TestServerSearch(t *testing.T) {
+	srv := newTestServer(t)
+	cases := []struct {
+		query string
+		ids   []int
+	}{
+		{"limit=3", []int{0, 1, 2}},
+		{"limit=2&offset=33", []int{33, 34}},
+		{"limit=2&offset=100", []int{}},
+		{"limit=3&order_field=Id&order_by=1", []int{34, 33, 32}},
+		{"limit=3&order_field=Age&order_by=-1", []int{1, 15, 23}},
+		{"query=Boyd", []int{0}},
+		{"query=Boyd+Wolf&order_field=Name&order_by=-1", []int{0}},
+		{"age_min=30&age_max=32&gender=female", []int{5, 22, 25}},
+		{"name_prefix=B", []int{0, 2, 5, 19, 22}},
+		{"name_prefix=B&age_max=1", []int{}},
+		{"limit=4&order_field=Age,Name&order_by=1,-1", []int{32, 13, 6, 26}},
+		{"limit=5&gender=male&order_field=Age,Id&order_by=-1,1", []int{23, 15, 0, 14, 2}},
+		// направление 0 у первого ключа - сортируем только по второму
+		{"limit=3&order_field=Age,Id&order_by=0,1", []int{34, 33, 32}},
+		{"limit=3&order_field=Age,Id", []int{0, 1, 2}},
+	}
+	for _, c := range cases {
+		code, body := get(t, srv, "token", c.query)
+		if code != http.StatusOK {
+			t.Errorf("[%s] status %d: %s", c.query, code, body)
+			continue
+		}
+		users := []User{}
+		if err := json.Unmarshal(body, &users); err != nil {
+			t.Errorf("[%s] %s", c.query, err)
+			continue
+		}
+		ids := make([]int, len(users))
+		for i, u := range users {
+			ids[i] = u.Id
+		}
+		if len(ids) != len(c.ids) {
+			t.Errorf("[%s] got ids %v, want %v", c.query, ids, c.ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != c.ids[i] {
+				t.Errorf("[%s] got ids %v, want %v", c.query, ids, c.ids)
+				break
+			}
+		}
+	}
+}
+
+func TestServerOrderByName(t *testing.T) {
+	srv := newTestServer(t)
+	_, body := get(t, srv, "token", "order_by=-1")
+	users := []User{}
+	if err := json.Unmarshal(body, &users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != defaultLimit {
+		t.Fatalf("got %d users, want default limit %d", len(users), defaultLimit)
+	}
+	for i := 1; i < len(users); i++ {
+		if users[i-1].Name > users[i].Name {
+			t.Fatalf("not sorted by name: %q before %q", users[i-1].Name, users[i].Name)
+		}
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	srv := newTestServer(t)
+	cases := []struct {
+		token, query string
+		status       int
+		err          string
+		field        string
+	}{
+		{"", "", http.StatusUnauthorized, "Bad AccessToken", ""},
+		{"token", "order_field=About", http.StatusBadRequest, ErrorBadOrderField, "About"},
+		{"token", "order_by=2", http.StatusBadRequest, "", ""},
+		{"token", "limit=-1", http.StatusBadRequest, "", ""},
+		{"token", "limit=ten", http.StatusBadRequest, "", ""},
+		{"token", "offset=-1", http.StatusBadRequest, "", ""},
+		{"token", "order_field=Age,About&order_by=1,1", http.StatusBadRequest, ErrorBadOrderField, "About"},
+		{"token", "order_field=Age,Name&order_by=1", http.StatusBadRequest, "", ""},
+		{"token", "age_min=old", http.StatusBadRequest, "", ""},
+		{"token", "age_max=-3", http.StatusBadRequest, "", ""},
+		{"token", "gender=robot", http.StatusBadRequest, "", ""},
+		{"token", "age_min=40&age_max=30", http.StatusBadRequest, "", ""},
+	}
+	for _, c := range cases {
+		code, body := get(t, srv, c.token, c.query)
+		if code != c.status {
+			t.Errorf("[%s] status %d, want %d", c.query, code, c.status)
+		}
+		resp := SearchErrorResponse{}
+		if err := json.Unmarshal(body, &resp); err != nil || resp.Error == "" {
+			t.Errorf("[%s] bad error body %s", c.query, body)
+		}
+		if c.err != "" && resp.Error != c.err {
+			t.Errorf("[%s] error %q, want %q", c.query, resp.Error, c.err)
+		}
+		if resp.Field != c.field {
+			t.Errorf("[%s] field %q, want %q", c.query, resp.Field, c.field)
+		}
+	}
+
+	srv.Token = "secret"
+	if code, _ := get(t, srv, "token", ""); code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d, want 401", code)
+	}
+	if code, _ := get(t, srv, "secret", ""); code != http.StatusOK {
+		t.Errorf("right token: status %d, want 200", code)
+	}
+}
+
+func searchUsers(t *testing.T, srv http.Handler, query string) []User {
+	code, body := get(t, srv, "token", query)
+	if code != http.StatusOK {
+		t.Fatalf("[%s] status %d: %s", query, code, body)
+	}
+	users := []User{}
+	if err := json.Unmarshal(body, &users); err != nil {
+		t.Fatalf("[%s] %s", query, err)
+	}
+	return users
+}
+
+func TestServerRelevance(t *testing.T) {
+	srv := newTestServer(t)
+	has := func(u User, word string) bool {
+		for _, w := range tokenize(u.About) {
+			if w == word {
+				return true
+			}
+		}
+		return false
+	}
+
+	best := searchUsers(t, srv, "limit=100&order_field=relevance&query=voluptate+commodo")
+	if len(best) == 0 || !has(best[0], "voluptate") || !has(best[0], "commodo") {
+		t.Fatalf("best match %+v does not have both words", best)
+	}
+	matching := 0
+	for _, row := range srv.Rows {
+		u := User{About: row.About}
+		if has(u, "voluptate") || has(u, "commodo") {
+			matching++
+		}
+	}
+	if len(best) != matching {
+		t.Errorf("found %d rows, %d have the words", len(best), matching)
+	}
+
+	worst := searchUsers(t, srv, "limit=100&order_field=relevance&order_by=-1&query=voluptate+commodo")
+	if len(worst) != len(best) || worst[len(worst)-1].Id != best[0].Id {
+		t.Errorf("order_by=-1 does not put the best match last")
+	}
+	desc := searchUsers(t, srv, "limit=100&order_field=relevance&order_by=1&query=voluptate+commodo")
+	if !reflect.DeepEqual(desc, best) {
+		t.Errorf("order_by=1 differs from the default best first")
+	}
+
+	for _, u := range searchUsers(t, srv, "limit=100&order_field=relevance&gender=male&query=voluptate+commodo") {
+		if u.Gender != "male" || !has(u, "voluptate") && !has(u, "commodo") {
+			t.Errorf("filtered relevance search found %+v", u)
+		}
+	}
+	if users := searchUsers(t, srv, "order_field=relevance&query=et"); len(users) != 0 {
+		t.Errorf("stop word found %d rows", len(users))
+	}
+	if users := searchUsers(t, srv, "limit=3&order_field=relevance"); len(users) != 3 || users[0].Id != 0 || users[2].Id != 2 {
+		t.Errorf("relevance without query should keep the dataset order, got %+v", users)
+	}
+	if users := searchUsers(t, srv, "limit=100&order_field=Age,relevance&order_by=-1,1&query=commodo"); len(users) == 0 {
+		t.Errorf("relevance as a second key found nothing")
+	} else {
+		for i := 1; i < len(users); i++ {
+			if users[i-1].Age > users[i].Age {
+				t.Fatalf("not sorted by age first: %d before %d", users[i-1].Age, users[i].Age)
+			}
+		}
+	}
+}
+
+func TestServerRelevanceLiteral(t *testing.T) {
+	rows, err := LoadDataset("../dataset.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// сервер без NewSearchServer строит индекс сам при первом запросе
+	srv := &SearchServer{Rows: rows}
+	want := searchUsers(t, newTestServer(t), "limit=5&order_field=relevance&query=voluptate+commodo")
+	if got := searchUsers(t, srv, "limit=5&order_field=relevance&query=voluptate+commodo"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}